@@ -55,7 +55,7 @@ func featureSetSection() []*gomd.Element {
 		b.H2("Feature set"),
 		b.NL(),
 		b.UL(
-			b.Bold("Builder API"), b.Textln(" — headings (H1–H6), text, bold, italic, code spans, images, links, rules, lists (UL/OL), block quotes, fenced code blocks."),
+			b.Bold("Builder API"), b.Textln(" — headings (H1–H6), text, bold, italic, code spans, images, links, rules, lists (UL/OL), block quotes, fenced code blocks, tables, task lists, strikethrough, footnotes."),
 			b.Bold("Compounder API"), b.Textln(" — ergonomic helpers for common sections and titled lists (e.g., Section2, UL3, OL2) that compose cleanly."),
 			b.Bold("Render quality"), b.Textln(" — newline collapsing, whitespace trimming, predictable list prefixes/indentation."),
 			b.Bold("Two parse routes"), b.Textln(" — (1) fast one-pass parser; (2) tokenize → parse pipeline with token positions."),
@@ -74,24 +74,19 @@ func benchmarksSection() []*gomd.Element {
 	return []*gomd.Element{
 		b.H2("Benchmarks (snapshot)"),
 		b.NL(),
-		b.UL(
-			// Old parser
-			b.Textln("Old parser (ParseCtx)"),
-			b.UL(
-				b.Textln("h3: ~100 ns/op, 168 B, 3 allocs"),
-				b.Textln("mixed: ~1.55 µs/op, 2.4 KB, 38 allocs"),
-				b.Textln("large: ~3.06 ms/op, 4.06 MB, 60k allocs"),
-			),
-			// Pipeline
-			b.Textln("Pipeline (Tokenize+ParseTokensCtx)"),
-			b.UL(
-				b.Textln("h3: ~1.37 µs/op, 4.9 KB, 12 allocs"),
-				b.Textln("mixed: ~5.15 µs/op, 15 KB, 59 allocs"),
-				b.Textln("large: ~9.57 ms/op, 27.8 MB, 78k allocs"),
-			),
+		b.Table([]gomd.Alignment{gomd.AlignLeft, gomd.AlignLeft, gomd.AlignRight, gomd.AlignRight, gomd.AlignRight},
+			b.Row(b.Text("Parser"), b.Text("Doc"), b.Text("Time"), b.Text("Memory"), b.Text("Allocs")),
+			b.Row(b.Text("Old parser (ParseCtx)"), b.Text("h3"), b.Text("~2.3 µs/op"), b.Text("768 B"), b.Text("14")),
+			b.Row(b.Text("Old parser (ParseCtx)"), b.Text("mixed"), b.Text("~18 µs/op"), b.Text("8.5 KB"), b.Text("112")),
+			b.Row(b.Text("Old parser (ParseCtx)"), b.Text("large"), b.Text("~45 ms/op"), b.Text("14.0 MB"), b.Text("174k")),
+			b.Row(b.Text("Pipeline (Tokenize+ParseTokensCtx)"), b.Text("h3"), b.Text("~5.2 µs/op"), b.Text("5.6 KB"), b.Text("26")),
+			b.Row(b.Text("Pipeline (Tokenize+ParseTokensCtx)"), b.Text("mixed"), b.Text("~32 µs/op"), b.Text("22.5 KB"), b.Text("190")),
+			b.Row(b.Text("Pipeline (Tokenize+ParseTokensCtx)"), b.Text("large"), b.Text("~73 ms/op"), b.Text("28.9 MB"), b.Text("264k")),
 		),
 		b.NL(),
-		b.Italicln("Takeaway: old parser is ~3x faster and ~6–7x lower memory on large docs; gap is even bigger on tiny docs."),
+		b.Italicln("Takeaway: old parser is ~1.6x faster and ~2x lower memory on large docs; gap is bigger on tiny docs."),
+		b.NL(),
+		b.Italicln("Most of the time goes to allocating elements: both parsers build paragraphs, list items and nested inline spans."),
 		b.NL(),
 		b.Italicln("Note: numbers vary by Go version/CPU; these are for relative shape, not absolute truth."),
 	}
@@ -201,6 +196,8 @@ func contributingSection() []*gomd.Element {
 			b.Text("Run tests: "), b.Codeln("go test ./pkg/gomd/... | ./pkg/bin/colorize"),
 			b.Text("Run benches: "), b.Codeln("go test -bench=. -benchmem -run '^$' ./pkg/gomd/..."),
 			b.Text("Regenerate token names (if TokenKind changes): "), b.Codeln("go generate ./pkg/gomd/..."),
+			b.Text("Check CommonMark conformance per spec section: "), b.Codeln("go test -run TestCommonMarkSpec -v ./pkg/gomd/"),
+			b.Text("Record newly passing spec examples: "), b.Codeln("go test -run TestCommonMarkSpec ./pkg/gomd/ -update-spec"),
 			b.Textln("Add ctx-cancel/timeout tests for long loops (see *_cancel_test.go)."),
 			b.Textln("Keep round-trip tests green (builder ⇄ parser ⇄ builder)."),
 		),
@@ -472,7 +469,7 @@ func main() {
 				"Deep nesting – partial support",
 				"Serve to a viewer – planned",
 				"Conversion to HTML – planned",
-				"CommonMark compatibility – aspirational (longer-term)",
+				"CommonMark compatibility – partial, measured against the spec examples (TestCommonMarkSpec)",
 			}),
 
 			benchmarksSection(),
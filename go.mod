@@ -2,7 +2,6 @@ module github.com/race-conditioned/go-md
 
 go 1.24.5
 
-require (
-	github.com/google/go-cmp v0.7.0 // indirect
-	golang.org/x/exp v0.0.0-20250811191247-51f88131bc50 // indirect
-)
+require github.com/google/go-cmp v0.7.0
+
+require golang.org/x/exp v0.0.0-20250811191247-51f88131bc50 // indirect
@@ -0,0 +1,180 @@
+package gomd
+
+import "strings"
+
+// parseAutolink parses an autolink "<scheme:...>" or "<user@example.com>" at the start of s.
+// It returns the link Element and the number of bytes of s it spans, or nil if s doesn't start with an autolink.
+func parseAutolink(s string) (*Element, int) {
+	if !strings.HasPrefix(s, "<") {
+		return nil, 0
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return nil, 0
+	}
+	target := s[1:end]
+	switch {
+	case isAbsoluteURI(target):
+		return &Element{Kind: EKLink, Text: target, Href: target, LinkStyle: LinkAuto}, end + 1
+	case isEmail(target):
+		return &Element{Kind: EKLink, Text: target, Href: "mailto:" + target, LinkStyle: LinkAuto}, end + 1
+	}
+	return nil, 0
+}
+
+// isAbsoluteURI checks for a scheme of 2-32 letters, digits, '+', '.' or '-' starting with a letter,
+// then a colon and no spaces, control characters or angle brackets.
+func isAbsoluteURI(s string) bool {
+	colon := strings.IndexByte(s, ':')
+	if colon < 2 || colon > 32 || !isASCIILetter(s[0]) {
+		return false
+	}
+	for i := 1; i < colon; i++ {
+		c := s[i]
+		if !isASCIILetter(c) && !isASCIIDigit(c) && c != '+' && c != '.' && c != '-' {
+			return false
+		}
+	}
+	for i := colon + 1; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] == '<' || s[i] == '>' || s[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmail checks for an email address as allowed in autolinks: a local part, '@' and a domain of dot-separated labels.
+func isEmail(s string) bool {
+	at := strings.IndexByte(s, '@')
+	if at < 1 {
+		return false
+	}
+	for i := 0; i < at; i++ {
+		c := s[i]
+		if !isASCIILetter(c) && !isASCIIDigit(c) && !strings.ContainsRune(".!#$%&'*+/=?^_`{|}~-", rune(c)) {
+			return false
+		}
+	}
+	for _, label := range strings.Split(s[at+1:], ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !isASCIILetter(label[i]) && !isASCIIDigit(label[i]) && label[i] != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bareURLAt returns the length of an extended (GFM) autolink starting at s[i], or 0.
+// A bare URL starts with "www.", "http://" or "https://" at the start of s, after whitespace or after one of "*_~(".
+// It runs up to the next space or '<', without trailing punctuation and unbalanced closing parentheses.
+func bareURLAt(s string, i int) int {
+	if i > 0 && !strings.ContainsRune(" \t\n*_~(", rune(s[i-1])) {
+		return 0
+	}
+	rest := s[i:]
+	var domainAt int
+	switch {
+	case strings.HasPrefix(rest, "www."):
+		domainAt = 0
+	case strings.HasPrefix(rest, "http://"):
+		domainAt = len("http://")
+	case strings.HasPrefix(rest, "https://"):
+		domainAt = len("https://")
+	default:
+		return 0
+	}
+
+	n := strings.IndexAny(rest, " \t\n<")
+	if n < 0 {
+		n = len(rest)
+	}
+	if !isBareDomain(rest[domainAt:n]) {
+		return 0
+	}
+
+	// trim trailing punctuation, closing parentheses that don't close anything and entity references
+	for n > domainAt {
+		c := rest[n-1]
+		switch {
+		case strings.ContainsRune("?!.,:*_~'\"", rune(c)):
+			n--
+			continue
+		case c == ')' && strings.Count(rest[:n], ")") > strings.Count(rest[:n], "("):
+			n--
+			continue
+		case c == ';':
+			if amp := strings.LastIndexByte(rest[:n], '&'); amp > domainAt && isAlnum(rest[amp+1:n-1]) {
+				n = amp
+				continue
+			}
+		}
+		break
+	}
+	if n <= domainAt || !isBareDomain(rest[domainAt:n]) {
+		return 0
+	}
+	return n
+}
+
+// isBareDomain checks that s starts with a domain of at least two dot-separated labels of letters, digits,
+// '-' and '_', with no underscores in the last two labels.
+func isBareDomain(s string) bool {
+	end := strings.IndexAny(s, "/?#")
+	if end < 0 {
+		end = len(s)
+	}
+	labels := strings.Split(s[:end], ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for i, label := range labels {
+		if label == "" {
+			return false
+		}
+		for j := 0; j < len(label); j++ {
+			c := label[j]
+			if !isASCIILetter(c) && !isASCIIDigit(c) && c != '-' && c != '_' {
+				return false
+			}
+		}
+		if i >= len(labels)-2 && strings.Contains(label, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// bareLink returns the link Element for a bare URL, "www." URLs link to http.
+func bareLink(url string) *Element {
+	href := url
+	if strings.HasPrefix(url, "www.") {
+		href = "http://" + url
+	}
+	return &Element{Kind: EKLink, Text: url, Href: href, LinkStyle: LinkBare}
+}
+
+// autolinkStyle returns LinkAuto if a link with the display text and href can be written as "<display>".
+func autolinkStyle(display, href string) LinkStyle {
+	if (display == href && isAbsoluteURI(href)) || (href == "mailto:"+display && isEmail(display)) {
+		return LinkAuto
+	}
+	return LinkInline
+}
+
+// isAlnum checks if s is a non-empty run of ASCII letters and digits.
+func isAlnum(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isASCIILetter(s[i]) && !isASCIIDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isASCIILetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+func isASCIIDigit(c byte) bool { return c >= '0' && c <= '9' }
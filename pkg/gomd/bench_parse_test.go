@@ -36,11 +36,14 @@ func dataset(t *testing.B) map[string]string {
 		t.Fatalf("read h3.md: %v", err)
 	}
 	ds := map[string]string{
-		"h3":    string(h3),
-		"ul10":  string(ul10),
-		"ol10":  string(ol10),
-		"mixed": "### Title\n- item\n1) my ordered item\npara _i_ **b** [x](y)\n",
-		"large": strings.Repeat("## Head\n- a **bold** and _italic_\n1) link: [x](y)\n\n", 2000),
+		"h3":     string(h3),
+		"ul10":   string(ul10),
+		"ol10":   string(ol10),
+		"mixed":  "### Title\n- item\n1) my ordered item\npara _i_ **b** [x](y)\n",
+		"large":  strings.Repeat("## Head\n- a **bold** and _italic_\n1) link: [x](y)\n\n", 2000),
+		"delims": strings.Repeat("*_", 16384),
+		"quotes": strings.Repeat(">", 8000) + " deep\n",
+		"spans":  strings.Repeat("`a <b ", 8000),
 	}
 	return ds
 }
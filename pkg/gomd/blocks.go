@@ -0,0 +1,582 @@
+package gomd
+
+import "strings"
+
+// codeFence describes the opening line of a fenced code block.
+type codeFence struct {
+	char   byte
+	length int
+	indent int
+	lang   string
+}
+
+// leadingIndent counts the leading spaces of a line (tabs count as 2, as in the Lexer) and returns the rest of the line.
+func leadingIndent(line string) (int, string) {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 2
+		default:
+			return indent, line[i:]
+		}
+	}
+	return indent, ""
+}
+
+// parseFenceOpen checks if the line opens a fenced code block: up to 3 spaces of indent, then a run of at least
+// three backticks or tildes, then an optional info string. The first word of the info string is the language.
+func parseFenceOpen(line string) (codeFence, bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || len(rest) < 3 || (rest[0] != '`' && rest[0] != '~') {
+		return codeFence{}, false
+	}
+
+	char := rest[0]
+	length := 0
+	for length < len(rest) && rest[length] == char {
+		length++
+	}
+	if length < 3 {
+		return codeFence{}, false
+	}
+
+	info := strings.TrimSpace(rest[length:])
+	// backtick fences can't have backticks in the info string, otherwise they'd be a code span
+	if char == '`' && strings.Contains(info, "`") {
+		return codeFence{}, false
+	}
+
+	lang := info
+	if i := strings.IndexAny(lang, " \t"); i >= 0 {
+		lang = lang[:i]
+	}
+	return codeFence{char: char, length: length, indent: indent, lang: lang}, true
+}
+
+// closes checks if the line is a closing fence for f: the same fence character, at least as long, and nothing but spaces after.
+func (f codeFence) closes(line string) bool {
+	indent, rest := leadingIndent(line)
+	if indent > 3 {
+		return false
+	}
+	length := 0
+	for length < len(rest) && rest[length] == f.char {
+		length++
+	}
+	return length >= f.length && onlySpaces(rest[length:])
+}
+
+// content strips up to the opening fence's indentation from a line inside the block.
+func (f codeFence) content(line string) string {
+	for i := 0; i < f.indent && len(line) > 0 && line[0] == ' '; i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// fence returns the opening (and closing) fence string.
+func (f codeFence) fence() string {
+	return strings.Repeat(string(f.char), f.length)
+}
+
+// pickFence returns the shortest backtick fence that can safely wrap code,
+// i.e. one longer than any backtick run that starts a line in the code.
+func pickFence(code string) string {
+	longest := 0
+	for _, line := range strings.Split(code, "\n") {
+		_, rest := leadingIndent(line)
+		run := 0
+		for run < len(rest) && rest[run] == '`' {
+			run++
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// stripQuoteMarker checks if the line starts with a blockquote marker ('>' after up to 3 spaces of indent)
+// and returns the rest of the line with the marker and one optional following space removed.
+func stripQuoteMarker(line string) (string, bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || !strings.HasPrefix(rest, ">") {
+		return line, false
+	}
+	rest = rest[1:]
+	if strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "\t") {
+		rest = rest[1:]
+	}
+	return rest, true
+}
+
+// stripQuoteMarkers removes every blockquote marker at the start of the line, as stripQuoteMarker does for one.
+func stripQuoteMarkers(line string) string {
+	for {
+		inner, ok := stripQuoteMarker(line)
+		if !ok {
+			return line
+		}
+		line = inner
+	}
+}
+
+// nestedQuotes follows the quotes nested in a blockquote for as long as a quote holds nothing but another quote,
+// so that deep nesting is stripped in one pass instead of being parsed again for every level. inner holds the lines
+// of the blockquote without its own markers and quoted tells which of them had one, the others being lazy
+// continuation lines. The markers of the nested quotes are stripped from inner in place. It returns how many quotes
+// are nested that way and how many markers were stripped from each line.
+func nestedQuotes(inner []string, quoted []bool) (int, []int) {
+	bare := make([]string, len(inner)) // the lines without any of their markers, as lazyContinues sees them
+	var live []int                     // the lines quoted at every level so far, the others stay lazy
+	for k, line := range inner {
+		bare[k] = stripQuoteMarkers(line)
+		if quoted[k] {
+			live = append(live, k)
+		}
+	}
+
+	stripped := make([]int, len(inner))
+	for depth := 0; ; depth++ {
+		// the nested quote must open on the first line and take every line, else it is not all the quote holds
+		for _, k := range live {
+			if _, ok := stripQuoteMarker(inner[k]); !ok && (k == 0 || !lazyContinues(bare[k-1], inner[k])) {
+				return depth, stripped
+			}
+		}
+		next := live[:0]
+		for _, k := range live {
+			if rest, ok := stripQuoteMarker(inner[k]); ok {
+				inner[k] = rest
+				stripped[k]++
+				next = append(next, k)
+			}
+		}
+		live = next
+	}
+}
+
+// nestQuote returns a quote of the children, nested in depth more quotes.
+func nestQuote(children []*Element, depth int) *Element {
+	el := &Element{Kind: EKQuote, Children: children}
+	for ; depth > 0; depth-- {
+		el = &Element{Kind: EKQuote, Children: []*Element{el}}
+	}
+	return el
+}
+
+// isRule checks if the line is a horizontal rule: at least three of the same '-', '*' or '_' and nothing else but spaces.
+func isRule(line string) bool {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || rest == "" || !strings.ContainsRune("-*_", rune(rest[0])) {
+		return false
+	}
+	marker := string(rest[0])
+	return strings.Count(rest, marker) >= 3 && strings.Trim(rest, " \t"+marker) == ""
+}
+
+// splitHardBreak splits the hard line break off the end of a line of paragraph text: two or more spaces, or a backslash.
+// It returns the text before the break and the break as written, or no break if the paragraph doesn't go on after the
+// line (cont is false). Trailing spaces are never content, so they are dropped either way, but a backslash then stays.
+func splitHardBreak(line string, cont bool) (string, string) {
+	text := strings.TrimRight(line, " ")
+	if onlySpaces(text) {
+		return line, ""
+	}
+	spaces := len(line) - len(text)
+	switch {
+	case !cont:
+		return text, ""
+	case spaces >= 2:
+		return text, line[len(text):]
+	case spaces == 0 && (len(text)-len(strings.TrimRight(text, "\\")))%2 == 1:
+		return text[:len(text)-1], "\\"
+	}
+	return text, ""
+}
+
+// parseATXHeading parses a heading line of 1-6 hashes followed by a space or the end of the line.
+// An optional closing sequence of hashes ("## Title ##") is dropped from the text and reported as closed.
+func parseATXHeading(line string) (level int, text string, closed bool, ok bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 {
+		return 0, "", false, false
+	}
+	for level < len(rest) && rest[level] == '#' {
+		level++
+	}
+	if level < 1 || level > 6 || (level < len(rest) && rest[level] != ' ' && rest[level] != '\t') {
+		return 0, "", false, false
+	}
+
+	text = strings.Trim(rest[level:], " \t")
+	// the closing run of hashes must be separated from the text by a space, "# C#" keeps its hash.
+	trimmed := strings.TrimRight(text, "#")
+	if trimmed != text && (trimmed == "" || strings.HasSuffix(trimmed, " ") || strings.HasSuffix(trimmed, "\t")) {
+		text = strings.TrimRight(trimmed, " \t")
+		closed = true
+	}
+	return level, text, closed, true
+}
+
+// isATXHeading checks if the line opens with 1-6 hashes followed by a space or the end of the line.
+func isATXHeading(line string) bool {
+	_, _, _, ok := parseATXHeading(line)
+	return ok
+}
+
+// setextLevel checks if the line is a setext heading underline, returning 1 for "===" and 2 for "---" underlines, or 0.
+func setextLevel(line string) int {
+	indent, rest := leadingIndent(line)
+	rest = strings.TrimRight(rest, " \t")
+	if indent > 3 || rest == "" || strings.Trim(rest, rest[:1]) != "" {
+		return 0
+	}
+	switch rest[0] {
+	case '=':
+		return 1
+	case '-':
+		return 2
+	}
+	return 0
+}
+
+// listMarker describes the marker that opens a list item.
+type listMarker struct {
+	kind   ListType
+	bullet byte
+	start  int
+	delim  byte
+}
+
+// list returns an empty list Element opened by the marker.
+func (m listMarker) list() *Element {
+	return &Element{Kind: EKList, ListKind: m.kind, Bullet: m.bullet, Start: m.start, Delim: m.delim, Children: []*Element{}}
+}
+
+// parseListMarker checks if s opens with a '-', '*' or '+' bullet or an ordered marker of 1-9 digits followed by '.' or ')',
+// followed by a space or the end of s. It returns the marker and its length.
+func parseListMarker(s string) (listMarker, int, bool) {
+	if s == "" {
+		return listMarker{}, 0, false
+	}
+	var m listMarker
+	n := 1
+	if isBullet(s[0]) {
+		m = listMarker{kind: ListUnordered, bullet: s[0]}
+	} else {
+		for n = 0; n < len(s) && isASCIIDigit(s[n]); n++ {
+			m.start = m.start*10 + int(s[n]-'0')
+		}
+		if n == 0 || n > 9 || n >= len(s) || (s[n] != '.' && s[n] != ')') {
+			return listMarker{}, 0, false
+		}
+		m.kind, m.delim = ListOrdered, s[n]
+		n++
+	}
+	if n < len(s) && s[n] != ' ' {
+		return listMarker{}, 0, false
+	}
+	return m, n, true
+}
+
+// isListMarkerLine checks if the line opens with a bullet such as "- " or an ordered marker such as "1." or "10)".
+// A rule such as "* * *" is not a list item.
+func isListMarkerLine(line string) bool {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || isRule(line) {
+		return false
+	}
+	_, _, ok := parseListMarker(rest)
+	return ok
+}
+
+// isBullet checks if c is one of the unordered list markers.
+func isBullet(c byte) bool { return c == '-' || c == '*' || c == '+' }
+
+// isAnyListMarkerLine checks if the line opens a list item at any indentation, as nested list items do.
+func isAnyListMarkerLine(line string) bool {
+	_, _, ok := parseListMarker(strings.TrimLeft(line, " \t"))
+	return ok && !isRule(line)
+}
+
+// stripIndent removes up to cols columns of leading indentation from the line, counting tabs as 2 as leadingIndent does.
+func stripIndent(line string, cols int) string {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case cols <= 0:
+			return line[i:]
+		case line[i] == ' ':
+			cols--
+		case line[i] == '\t':
+			cols -= 2
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+// itemContinuation finds the lines after the first line of a list item at i that belong to the item: lines indented
+// to the item's content column, such as the items of a nested list, blank lines followed by such lines, and lazy
+// continuation lines of the paragraph the item starts with (first is its text), or of the paragraph of a quote it
+// starts with. Less indented list marker lines end the item, they belong to the list. It returns the index after the
+// item's last line.
+func itemContinuation(lines []string, i int, first string, contentCol int) int {
+	end := i + 1
+	prev := first
+	for j := i + 1; j < len(lines); j++ {
+		line := lines[j]
+		if onlySpaces(line) {
+			continue
+		}
+		indent, _ := leadingIndent(line)
+		if indent < contentCol && isAnyListMarkerLine(line) {
+			break
+		}
+		if indent >= contentCol {
+			prev = stripIndent(line, contentCol)
+		} else if _, quoted := stripQuoteMarker(prev); j == end && isParagraphLine(line) && (isParagraphLine(prev) || (quoted && lazyContinues(prev, line))) {
+			prev = line
+		} else {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// isInlineItem checks if the text of a list item that ends on its first line is a line of inline text, which is left
+// to the list. Any other item, such as one holding a heading, a quote or nothing, is parsed into its own blocks.
+func isInlineItem(text string) bool {
+	if _, ok := parseLinkDef(text); ok {
+		return false
+	}
+	return isParagraphLine(text)
+}
+
+// hasBlankLine checks if any of the lines is blank.
+func hasBlankLine(lines []string) bool {
+	for _, line := range lines {
+		if onlySpaces(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// startsBlock checks if the line opens a block other than a paragraph.
+func startsBlock(line string) bool {
+	if onlySpaces(line) || isRule(line) || isATXHeading(line) || isListMarkerLine(line) {
+		return true
+	}
+	if _, _, ok := parseFootnoteDef(line); ok {
+		return true
+	}
+	if _, ok := parseFenceOpen(line); ok {
+		return true
+	}
+	if htmlBlockStart(line, true) > 0 {
+		return true
+	}
+	_, ok := stripQuoteMarker(line)
+	return ok
+}
+
+// lazyContinues checks if line is a "lazy" continuation of a blockquote, i.e. a line without a '>' marker
+// that carries on the paragraph text of the previous quoted line (prev, with its markers removed).
+func lazyContinues(prev, line string) bool {
+	if startsBlock(line) {
+		return false
+	}
+	prev = stripQuoteMarkers(prev)
+	if onlySpaces(prev) || isRule(prev) || isATXHeading(prev) {
+		return false
+	}
+	_, fenced := parseFenceOpen(prev)
+	return !fenced
+}
+
+// stripCodeIndent removes the four columns of indentation that open an indented code block line.
+// Unlike leadingIndent, a tab advances to the next tab stop of 4, so a single tab is enough.
+// It reports false if the line is indented less than that.
+func stripCodeIndent(line string) (string, bool) {
+	cols := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			cols++
+		case '\t':
+			cols += 4 - cols%4
+		default:
+			return line, false
+		}
+		if cols >= 4 {
+			return line[i+1:], true
+		}
+	}
+	return "", false
+}
+
+// isParagraphLine checks if the line is plain paragraph text rather than blank or the start of another block.
+func isParagraphLine(line string) bool {
+	return !startsBlock(line) && setextLevel(line) == 0
+}
+
+// indentedCode renders code as an indented code block, blank lines stay empty.
+func indentedCode(code string) string {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "    " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseLinkDef parses a link reference definition line: `[label]: href "title"`.
+// The destination may be wrapped in angle brackets, and the optional title in double quotes, single quotes or parentheses.
+func parseLinkDef(line string) (*Element, bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || !strings.HasPrefix(rest, "[") {
+		return nil, false
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 || end+1 >= len(rest) || rest[end+1] != ':' {
+		return nil, false
+	}
+	label := rest[1:end]
+	// a label starting with '^' is a footnote's
+	if strings.TrimSpace(label) == "" || strings.Contains(label, "[") || strings.HasPrefix(label, "^") {
+		return nil, false
+	}
+
+	rest = strings.TrimLeft(rest[end+2:], " \t")
+	var href string
+	if strings.HasPrefix(rest, "<") {
+		gt := strings.IndexByte(rest, '>')
+		if gt < 0 {
+			return nil, false
+		}
+		href, rest = rest[1:gt], rest[gt+1:]
+	} else {
+		n := strings.IndexAny(rest, " \t")
+		if n < 0 {
+			n = len(rest)
+		}
+		href, rest = rest[:n], rest[n:]
+		if href == "" {
+			return nil, false
+		}
+	}
+
+	title := strings.TrimSpace(rest)
+	if title != "" {
+		if (rest[0] != ' ' && rest[0] != '\t') || len(title) < 2 || !closesTitle(title[0], title[len(title)-1]) {
+			return nil, false
+		}
+		title = title[1 : len(title)-1]
+	}
+	return &Element{Kind: EKLinkDef, Ref: label, Href: unescapeText(href), Title: unescapeText(title), LineBreak: true}, true
+}
+
+// closesTitle checks if open and close are a matching pair of link title delimiters.
+func closesTitle(first, last byte) bool {
+	switch first {
+	case '"', '\'':
+		return last == first
+	case '(':
+		return last == ')'
+	}
+	return false
+}
+
+// normalizeLabel folds a link label for matching: case-insensitive, with runs of whitespace collapsed.
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// collectDefinitions finds the link reference definitions and the labels of the footnote definitions in the lines,
+// including those inside blockquotes, so references to them are known before they are defined.
+// Fenced code blocks are skipped. When a label is defined more than once, the first definition wins.
+func collectDefinitions(lines []string) map[string]*Element {
+	defs := map[string]*Element{}
+	var fence *codeFence
+	for _, line := range lines {
+		line = stripQuoteMarkers(line)
+		if fence != nil {
+			if fence.closes(line) {
+				fence = nil
+			}
+			continue
+		}
+		if f, ok := parseFenceOpen(line); ok {
+			fence = &f
+			continue
+		}
+		if def, ok := parseLinkDef(line); ok {
+			if _, seen := defs[normalizeLabel(def.Ref)]; !seen {
+				defs[normalizeLabel(def.Ref)] = def
+			}
+		}
+		if label, _, ok := parseFootnoteDef(line); ok {
+			if _, seen := defs[footnoteKey(label)]; !seen {
+				defs[footnoteKey(label)] = &Element{Kind: EKFootnoteDef, Ref: label, LineBreak: true}
+			}
+		}
+	}
+	return defs
+}
+
+// linkDef returns the link reference definition of the label among defs, or nil. The footnote definitions among
+// them never define a link.
+func linkDef(defs map[string]*Element, label string) *Element {
+	if def := defs[normalizeLabel(label)]; def != nil && def.Kind == EKLinkDef {
+		return def
+	}
+	return nil
+}
+
+// refSuffix checks what follows the closing bracket of a link's text for a reference: "[ref]" or "[]".
+// It returns the label as written (the link text for the collapsed and shortcut styles), the style
+// and the number of bytes of rest taken by the reference.
+func refSuffix(text, rest string) (string, LinkStyle, int) {
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.IndexByte(rest, ']'); end > 0 && !strings.Contains(rest[1:end], "[") {
+			if end == 1 {
+				return text, LinkCollapsed, 2
+			}
+			return rest[1:end], LinkFull, end + 1
+		}
+	}
+	return text, LinkShortcut, 0
+}
+
+// linkDefinition returns the markdown for a link reference definition.
+func linkDefinition(el *Element) string {
+	href := el.Href
+	if href == "" || strings.ContainsAny(href, " \t") {
+		href = "<" + href + ">"
+	}
+	return "[" + el.Ref + "]: " + href + linkTitle(el.Title)
+}
+
+// linkTitle returns the title of a link or definition with a leading space, quoted so that it can be parsed back.
+func linkTitle(title string) string {
+	switch {
+	case title == "":
+		return ""
+	case !strings.Contains(title, `"`):
+		return ` "` + title + `"`
+	case !strings.Contains(title, "'"):
+		return " '" + title + "'"
+	default:
+		return " (" + title + ")"
+	}
+}
@@ -1,6 +1,7 @@
 package gomd
 
 import (
+	"slices"
 	"strings"
 )
 
@@ -14,6 +15,14 @@ func (b *Builder) heading(level int, text string) *Element {
 	return &Element{Kind: EKHeading, Level: level, LineBreak: true, Text: text}
 }
 
+// Heading returns an Element pointer representing a heading written in the given style.
+// Setext headings only exist for levels 1 and 2, other levels fall back to ATX headings on render.
+func (b *Builder) Heading(level int, style HeadingStyle, text string) *Element {
+	el := b.heading(level, text)
+	el.Style = style
+	return el
+}
+
 // H1 returns an Element pointer representing a level-1 heading.
 func (b *Builder) H1(text string) *Element { return b.heading(1, text) }
 
@@ -33,6 +42,7 @@ func (b *Builder) H5(text string) *Element { return b.heading(5, text) }
 func (b *Builder) H6(text string) *Element { return b.heading(6, text) }
 
 // Text returns an Element pointer representing markdown text.
+// The text is literal, markdown punctuation that would be read as markup is escaped on render. To write markdown as is, use Raw.
 func (b *Builder) Text(text string) *Element { return &Element{Kind: EKText, Text: text} }
 
 // Textln returns an Element pointer representing a markdown text followed by a newline character.
@@ -42,43 +52,104 @@ func (b *Builder) Textln(text string) *Element {
 
 // Bold returns an Element pointer representing bold markdown text.
 func (b *Builder) Bold(text string) *Element {
-	return &Element{Kind: EKBold, Text: inlineWrap("**", escapeInline(text))}
+	return &Element{Kind: EKBold, Delim: '*', Text: text}
 }
 
 // Boldln returns an Element pointer representing bold markdown text followed by a newline character.
 func (b *Builder) Boldln(text string) *Element {
-	return &Element{Kind: EKBold, LineBreak: true, Text: inlineWrap("**", escapeInline(text))}
+	return &Element{Kind: EKBold, Delim: '*', LineBreak: true, Text: text}
 }
 
 // Italic returns an Element pointer representing italic markdown text.
 func (b *Builder) Italic(text string) *Element {
-	return &Element{Kind: EKItalic, Text: inlineWrap("_", escapeInline(text))}
+	return &Element{Kind: EKItalic, Delim: '_', Text: text}
 }
 
 // Italicln returns an Element pointer representing italic markdown text followed by a newline character.
 func (b *Builder) Italicln(text string) *Element {
-	return &Element{Kind: EKItalic, LineBreak: true, Text: inlineWrap("_", escapeInline(text))}
+	return &Element{Kind: EKItalic, Delim: '_', LineBreak: true, Text: text}
+}
+
+// Strong returns an Element pointer representing bold markdown text made of inline Children,
+// so that it can nest italic text, links and code spans.
+func (b *Builder) Strong(children ...*Element) *Element {
+	return &Element{Kind: EKBold, Delim: '*', Children: children}
+}
+
+// Strongln returns an Element pointer representing bold markdown text made of inline Children followed by a newline character.
+func (b *Builder) Strongln(children ...*Element) *Element {
+	return &Element{Kind: EKBold, Delim: '*', LineBreak: true, Children: children}
+}
+
+// Emph returns an Element pointer representing italic markdown text made of inline Children,
+// so that it can nest bold text, links and code spans.
+func (b *Builder) Emph(children ...*Element) *Element {
+	return &Element{Kind: EKItalic, Delim: '_', Children: children}
+}
+
+// Emphln returns an Element pointer representing italic markdown text made of inline Children followed by a newline character.
+func (b *Builder) Emphln(children ...*Element) *Element {
+	return &Element{Kind: EKItalic, Delim: '_', LineBreak: true, Children: children}
+}
+
+// Strike returns an Element pointer representing struck text, written between "~~".
+func (b *Builder) Strike(text string) *Element {
+	return &Element{Kind: EKStrike, Fence: "~~", Text: text}
+}
+
+// Strikeln returns an Element pointer representing struck text followed by a newline character.
+func (b *Builder) Strikeln(text string) *Element {
+	return &Element{Kind: EKStrike, Fence: "~~", LineBreak: true, Text: text}
+}
+
+// Del returns an Element pointer representing struck text made of inline Children,
+// so that it can nest bold and italic text, links and code spans.
+func (b *Builder) Del(children ...*Element) *Element {
+	return &Element{Kind: EKStrike, Fence: "~~", Children: children}
+}
+
+// Delln returns an Element pointer representing struck text made of inline Children followed by a newline character.
+func (b *Builder) Delln(children ...*Element) *Element {
+	return &Element{Kind: EKStrike, Fence: "~~", LineBreak: true, Children: children}
 }
 
 // Code returns an Element pointer representing markdown inline code (a code span). For Fenced blocks, use CodeBlock.
+// The code is literal: it is written between the shortest run of backticks it doesn't hold itself, so backticks and
+// backslashes inside it read back as they are.
 func (b *Builder) Code(text string) *Element {
-	return &Element{Kind: EKCodeSpan, Text: inlineWrap("`", escapeBackticks(text))}
+	return &Element{Kind: EKCodeSpan, Text: text}
 }
 
 // Codeln returns an Element pointer representing markdown inline code (a code span) followed by a newline character. For Fenced blocks, use CodeBlock..
 func (b *Builder) Codeln(text string) *Element {
-	return &Element{Kind: EKCodeSpan, LineBreak: true, Text: inlineWrap("`", escapeBackticks(text))}
+	return &Element{Kind: EKCodeSpan, LineBreak: true, Text: text}
 }
 
 // CodeBlock returns an Element pointer representing a markdown fenced code block.
+// The fence is chosen on render so that backticks inside the code can't close it early.
 func (b *Builder) CodeBlock(lang, code string) *Element {
 	return &Element{
-		Kind: EKCodeBlock,
-		Lang: lang,
-		Text: code,
+		Kind:      EKCodeBlock,
+		LineBreak: true,
+		Lang:      lang,
+		Text:      code,
 	}
 }
 
+// IndentedCode returns an Element pointer representing a markdown code block indented by four spaces.
+// An indented code block can't interrupt a paragraph, so it needs an NL before it when following text.
+func (b *Builder) IndentedCode(code string) *Element {
+	return &Element{Kind: EKCodeBlock, LineBreak: true, Indented: true, Text: code}
+}
+
+// Paragraph returns an Element pointer representing a paragraph made of inline Children.
+// Lines end after the children with a LineBreak, such as Textln, and the last line ends the paragraph.
+// Build writes a blank line between a paragraph and the text, paragraph or list before or after it wherever they
+// would otherwise run together, so there is no need for an NL between them.
+func (b *Builder) Paragraph(inline ...*Element) *Element {
+	return &Element{Kind: EKParagraph, Children: inline}
+}
+
 // NL returns an Element pointer representing a markdown nl character. The Builder.Build method ignores all newlines beyond two sequentially.
 func (b *Builder) NL() *Element { return &Element{Kind: EKNewLine, LineBreak: true} }
 
@@ -87,7 +158,26 @@ func (b *Builder) NL() *Element { return &Element{Kind: EKNewLine, LineBreak: tr
 // This allows for custom nesting.
 // Any Element (including a UL Element) can be nested in a UL.
 func (b *Builder) UL(Children ...*Element) *Element {
-	return &Element{Kind: EKList, ListKind: ListUnordered, Children: Children}
+	return &Element{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: Children}
+}
+
+// ULWith returns an Element pointer representing the bounds of an unordered list written with the bullet '-', '*' or '+'.
+func (b *Builder) ULWith(bullet byte, Children ...*Element) *Element {
+	return &Element{Kind: EKList, ListKind: ListUnordered, Bullet: bullet, Children: Children}
+}
+
+// Item returns an Element pointer representing a list item made of Children, to pass to UL or OL.
+// The Children are blocks: every line they render after the first is indented to line up with the text after the marker,
+// so an item can hold several paragraphs, code blocks and nested lists.
+func (b *Builder) Item(Children ...*Element) *Element {
+	return &Element{Kind: EKListItem, Children: Children}
+}
+
+// Task returns an Element pointer representing a task list item made of Children, to pass to UL or OL.
+// It is written with a "[x]" box after the list marker when checked, and a "[ ]" box otherwise. As with Item,
+// the Children are blocks.
+func (b *Builder) Task(checked bool, Children ...*Element) *Element {
+	return &Element{Kind: EKListItem, Task: true, Checked: checked, Children: Children}
 }
 
 // OL returns an Element pointer representing the bounds of an ordered list.
@@ -95,18 +185,93 @@ func (b *Builder) UL(Children ...*Element) *Element {
 // This allows for custom nesting.
 // Any Element (including an OL Element) can be nested in an OL.
 func (b *Builder) OL(Children ...*Element) *Element {
-	return &Element{Kind: EKList, ListKind: ListOrdered, Children: Children}
+	return &Element{Kind: EKList, ListKind: ListOrdered, Start: 1, Delim: '.', Children: Children}
+}
+
+// OLFrom returns an Element pointer representing the bounds of an ordered list numbered from start,
+// with delim ('.' or ')') after each number.
+func (b *Builder) OLFrom(start int, delim byte, Children ...*Element) *Element {
+	return &Element{Kind: EKList, ListKind: ListOrdered, Start: start, Delim: delim, Children: Children}
+}
+
+// link is the single source of truth for inline links. When the display text is the link itself, such as
+// Link("https://go.dev", "https://go.dev") or Link("me@x.io", "mailto:me@x.io"), it is written as an autolink "<https://go.dev>".
+func (b *Builder) link(display, link string) *Element {
+	if autolinkStyle(display, link) == LinkAuto {
+		return &Element{Kind: EKLink, Text: display, Href: link, LinkStyle: LinkAuto}
+	}
+	return &Element{Kind: EKLink, Text: display, Href: link}
 }
 
 // Link returns an Element pointer representing a markdown link.
 func (b *Builder) Link(display, link string) *Element {
 	// INFO: trailing space is used to allow for spacing multiple links
-	return &Element{Kind: EKLink, Text: escapeLinkText(display), Href: escapeURL(link)}
+	return b.link(display, link)
 }
 
 // Linkln returns an Element pointer representing a markdown link followed by a newline character.
 func (b *Builder) Linkln(display, link string) *Element {
-	return &Element{Kind: EKLink, LineBreak: true, Text: escapeLinkText(display), Href: escapeURL(link)}
+	el := b.link(display, link)
+	el.LineBreak = true
+	return el
+}
+
+// LinkTo returns an Element pointer representing a markdown link whose display is made of inline Children.
+func (b *Builder) LinkTo(link string, children ...*Element) *Element {
+	return &Element{Kind: EKLink, Href: link, Children: children}
+}
+
+// LinkToln returns an Element pointer representing a markdown link whose display is made of inline Children
+// followed by a newline character.
+func (b *Builder) LinkToln(link string, children ...*Element) *Element {
+	return &Element{Kind: EKLink, LineBreak: true, Href: link, Children: children}
+}
+
+// refLink is the single source of truth for reference links, an empty ref makes a collapsed "[display][]" link.
+func (b *Builder) refLink(display, ref, link string) *Element {
+	el := &Element{Kind: EKLink, Text: display, Href: link, Ref: ref, LinkStyle: LinkFull}
+	if ref == "" {
+		el.Ref = escapeLinkText(display)
+		el.LinkStyle = LinkCollapsed
+	}
+	return el
+}
+
+// RefLink returns an Element pointer representing a reference-style markdown link "[display][ref]".
+// Its definition "[ref]: link" is written at the end of the document by Build, unless the elements hold a LinkDef for ref.
+// An empty ref uses the display text as the label.
+func (b *Builder) RefLink(display, ref, link string) *Element { return b.refLink(display, ref, link) }
+
+// RefLinkln returns an Element pointer representing a reference-style markdown link followed by a newline character.
+func (b *Builder) RefLinkln(display, ref, link string) *Element {
+	el := b.refLink(display, ref, link)
+	el.LineBreak = true
+	return el
+}
+
+// LinkDef returns an Element pointer representing a link reference definition "[ref]: link "title"".
+// Use it to place a definition somewhere other than the end of the document. The title is optional.
+func (b *Builder) LinkDef(ref, link, title string) *Element {
+	return &Element{Kind: EKLinkDef, LineBreak: true, Ref: ref, Href: link, Title: title}
+}
+
+// Footnote returns an Element pointer representing a footnote reference with the content of the footnote attached:
+// inline Children, or blocks such as Paragraphs. Build numbers it in order of first use, writes "[^1]" in its place
+// and its content as a definition at the end of the document.
+func (b *Builder) Footnote(content ...*Element) *Element {
+	return &Element{Kind: EKFootnoteRef, Children: content}
+}
+
+// FootnoteRef returns an Element pointer representing a reference "[^label]" to the footnote defined by a FootnoteDef
+// of the same label. A label that is a number is renumbered in order of first use on render.
+func (b *Builder) FootnoteRef(label string) *Element {
+	return &Element{Kind: EKFootnoteRef, Ref: label}
+}
+
+// FootnoteDef returns an Element pointer representing the definition "[^label]: ..." of a footnote made of Children,
+// inline elements or blocks. Wherever it is placed, Build writes it at the end of the document.
+func (b *Builder) FootnoteDef(label string, Children ...*Element) *Element {
+	return &Element{Kind: EKFootnoteDef, LineBreak: true, Ref: label, Children: Children}
 }
 
 // Img returns an Element pointer representing a markdown image followed by a newline character.
@@ -114,26 +279,90 @@ func (b *Builder) Img(alt, link string) *Element {
 	return &Element{Kind: EKImage, LineBreak: true, Alt: alt, Href: link}
 }
 
+// RefImg returns an Element pointer representing a reference-style markdown image "![alt][ref]" followed by a newline character.
+// As with RefLink, its definition is written at the end of the document, and an empty ref uses the alt text as the label.
+func (b *Builder) RefImg(alt, ref, link string) *Element {
+	el := &Element{Kind: EKImage, LineBreak: true, Alt: alt, Href: link, Ref: ref, LinkStyle: LinkFull}
+	if ref == "" {
+		el.Ref = alt
+		el.LinkStyle = LinkCollapsed
+	}
+	return el
+}
+
+// HardBreak returns an Element pointer representing a hard line break, which starts a new line inside a paragraph.
+// It is written as a backslash at the end of the line. A line ended by LineBreak alone is a soft break, which
+// renderers may reflow. In a list, put the lines of an item with a hard break in an Item.
+func (b *Builder) HardBreak() *Element {
+	return &Element{Kind: EKHardBreak, LineBreak: true, Fence: "\\"}
+}
+
+// HTMLBlock returns an Element pointer representing a block of raw HTML, such as "<details>\n<summary>More</summary>",
+// which is written as it is. A block opened by a block element like <div> runs to the next blank line, so Build
+// writes one after it wherever more text follows.
+func (b *Builder) HTMLBlock(html string) *Element {
+	return &Element{Kind: EKHTMLBlock, LineBreak: true, Text: html}
+}
+
+// HTMLInline returns an Element pointer representing inline raw HTML, such as "<br>" or "<kbd>", written as it is.
+func (b *Builder) HTMLInline(html string) *Element { return &Element{Kind: EKHTMLInline, Text: html} }
+
 // Rule returns an Element pointer representing a markdown rule, it will always pad a full newline between other Text.
 func (b *Builder) Rule() *Element {
-	return &Element{Kind: EKRule, LineBreak: true, Text: "\n---\n"}
+	return &Element{Kind: EKRule, LineBreak: true, Fence: "---"}
+}
+
+// Raw returns an Element pointer representing pre-formatted markdown, which is written as it is without any escaping.
+// It is unsafe in that nothing checks the markdown, and it is how markdown written into Text before was rendered.
+func (b *Builder) Raw(markdown string) *Element { return &Element{Kind: EKRaw, Text: markdown} }
+
+// Rawln returns an Element pointer representing pre-formatted markdown followed by a newline character.
+func (b *Builder) Rawln(markdown string) *Element {
+	return &Element{Kind: EKRaw, LineBreak: true, Text: markdown}
 }
 
 // CodeFence renders a fenced, optionally language-tagged block.
 func (b *Builder) CodeFence(lang, code string) *Element { return b.CodeBlock(lang, code) }
 
 // Quote renders a Markdown blockquote with Children.
+// Every line rendered by the Children is prefixed with "> ", so quotes can be nested and can hold lists and code blocks.
 func (b *Builder) Quote(Children ...*Element) *Element {
 	return &Element{Kind: EKQuote, Children: Children}
 }
 
+// Table returns an Element pointer representing a GFM table made of a header Row and body Rows, with columns aligned
+// by align (AlignNone for columns past its end). The header sets the number of columns: body rows are padded with
+// empty cells or cut to fit. The cells of each column are padded to the same width on render.
+func (b *Builder) Table(align []Alignment, header *Element, rows ...*Element) *Element {
+	return &Element{Kind: EKTable, LineBreak: true, Align: align, Children: append([]*Element{header}, rows...)}
+}
+
+// Row returns an Element pointer representing a row of a table, to pass to Table.
+// Cells can be passed as Cell elements, any other element is a cell of its own.
+func (b *Builder) Row(cells ...*Element) *Element {
+	row := &Element{Kind: EKTableRow}
+	for _, cell := range cells {
+		if cell.Kind != EKTableCell {
+			cell = b.Cell(cell)
+		}
+		row.Children = append(row.Children, cell)
+	}
+	return row
+}
+
+// Cell returns an Element pointer representing a table cell made of inline Children, to pass to Row.
+// A pipe in the cell is escaped on render, so it doesn't end the cell.
+func (b *Builder) Cell(inline ...*Element) *Element {
+	return &Element{Kind: EKTableCell, Children: inline}
+}
+
 func (b *Builder) cleanLastElement(elements []*Element) {
 	if len(elements) == 0 {
 		return
 	}
 
 	lastEl := elements[len(elements)-1]
-	if !lastEl.LineBreak && lastEl.Kind != EKList {
+	if !lastEl.LineBreak && lastEl.Kind != EKList && lastEl.Kind != EKQuote && lastEl.Kind != EKParagraph {
 		lastEl.LineBreak = true
 	}
 }
@@ -144,22 +373,56 @@ func (b *Builder) renderText(ctx *renderCtx, buf *strings.Builder, el *Element)
 
 // Build consumes Element pointers.
 // It uses a recursive render function to convert each Element Text into an equivalent in markdown.
+// Footnotes are numbered in order of first use and their definitions are written at the end of the document,
+// see CheckFootnotes for the references and definitions that don't match up.
 func (b *Builder) Build(elements ...*Element) string {
 	var buf strings.Builder
 	ctx := &renderCtx{
 		frames:      []listFrame{},
+		bullet:      b.Bullet,
+		emphasis:    b.Emphasis,
+		html:        b.HTML,
+		notes:       collectFootnotes(elements),
 		lineBuffer:  &strings.Builder{},
 		startOfLine: false,
 	}
 
-	b.cleanLastElement(elements)
+	// footnote definitions write nothing in place, the body of the document goes on around them
+	body := slices.DeleteFunc(slices.Clone(elements), func(el *Element) bool { return el != nil && el.Kind == EKFootnoteDef })
+	b.cleanLastElement(body)
 
-	for _, el := range elements {
+	var prev *Element
+	for _, el := range body {
 		if el == nil {
 			continue
 		}
+		ctx.separate(b, &buf, prev, el)
 		b.renderText(ctx, &buf, el)
+		prev = el
+	}
+
+	// reference links without a LinkDef of their own get their definitions at the end of the document
+	if defs := pendingDefinitions(elements); len(defs) > 0 {
+		ctx.writeQuoted(&buf, "\n")
+		for _, def := range defs {
+			b.renderText(ctx, &buf, def)
+		}
+	}
+
+	if len(ctx.notes.defs) > 0 {
+		ctx.writeQuoted(&buf, "\n")
+		for _, def := range ctx.notes.defs {
+			ctx.writeQuoted(&buf, ctx.footnoteDefinition(b, def)+"\n")
+		}
 	}
 
 	return ctx.cleanRender(buf.String())
 }
+
+// CheckFootnotes reports the footnotes of the elements that don't match up: the labels of the references without
+// a definition, which Build writes as they are, and the labels of the definitions nothing refers to, which Build
+// still writes after the others.
+func (b *Builder) CheckFootnotes(elements ...*Element) (missing, unused []string) {
+	notes := collectFootnotes(elements)
+	return notes.missing, notes.unused
+}
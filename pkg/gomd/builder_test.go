@@ -22,6 +22,8 @@ func TestSimpleBuilderCases(t *testing.T) {
 		{"h4", "h4.md", b.Build(b.H4("Header test"))},
 		{"h5", "h5.md", b.Build(b.H5("Header test"))},
 		{"h6", "h6.md", b.Build(b.H6("Header test"))},
+		{"setext1", "setext1.md", b.Build(b.Heading(1, HeadingSetext, "Title"), b.NL(), b.Heading(2, HeadingSetext, "Sub"))},
+		{"atx1", "atx1.md", b.Build(b.Heading(2, HeadingATXClosed, "Title"), b.NL(), b.H1("C#"))},
 
 		// TEXT
 		{"text1", "text1.md", b.Build(b.Text("hi"))},
@@ -45,6 +47,46 @@ func TestSimpleBuilderCases(t *testing.T) {
 		{"link2", "link2.md", b.Build(b.Link("google", "https://google.com"), b.Linkln("amazon", "https://amazon.com"))},
 		{"link2ln", "link2.md", b.Build(b.Link("google", "https://google.com"), b.Linkln("amazon", "https://amazon.com"))},
 
+		// NESTED INLINE
+		{"nested1", "nested1.md", b.Build(b.Strongln(b.Text("bold with "), b.Italic("italic"), b.Text(" and "), b.Link("link", "x")))},
+		{"nested2", "nested2.md", b.Build(b.Emph(b.Text("see "), b.LinkTo("https://x.io", b.Text("the "), b.Bold("docs")), b.Text(" and "), b.Code("code")), b.Textln(" please"))},
+
+		// REFERENCE LINKS
+		{"refs2", "refs2.md", b.Build(b.Text("Read "), b.RefLink("the docs", "docs", "https://example.com/docs"), b.Text(" and "), b.RefLinkln("Go", "", "https://go.dev"), b.RefImg("logo", "img", "logo.png"))},
+		{"refs3", "refs3.md", b.Build(b.Quote(b.RefLink("quoted", "q", "https://q.example"), b.Textln(" link")), b.NL(), b.Textln("[undefined] and [x][nope] stay text"), b.NL(), b.CodeBlock("", "[q]: not-a-definition"), b.NL(), b.LinkDef("Q", "https://q.example", ""))},
+
+		// AUTOLINKS
+		{"autolink2", "autolink2.md", b.Build(b.Text("Mail "), b.Link("me@example.com", "mailto:me@example.com"), b.Text(" or visit "), b.Linkln("https://go.dev", "https://go.dev"))},
+
+		// ESCAPES
+		{"escape1", "escape1.md", b.Build(b.Textln("Not *bold* nor _italic_ or [link](x) or `code`"), b.Textln("# not a heading"), b.Textln("1. not a list"), b.Textln("+ nor this"), b.Textln(`C:\path stays`), b.Boldln("a * b"))},
+		{"escape2", "escape2.md", b.Build(b.Textln("[foo]: /url"), b.NL(), b.Textln("    four spaces"), b.NL(), b.Textln("\t# deep"))},
+		{"escape3", "escape3.md", b.Build(b.Textln("| a | b |"), b.Textln("| - | - |"), b.NL(), b.Textln("--- | ---"))},
+
+		// LITERAL
+		{"literal1", "literal1.md", b.Build(b.H1("The *real* `gomd`"), b.NL(), b.Raw("**already** bold"), b.Textln(" *not*"), b.Rule(), b.Link("a_b", "x(1)"), b.Codeln(`a\b`))},
+
+		// STRIKE
+		{"strike1", "strike1.md", b.Build(b.Strike("gone"), b.Text(" and "), &Element{Kind: EKStrike, Fence: "~", Text: "single"}, b.Text(" with "),
+			b.Strong(b.Text("bold "), b.Strike("inside")), b.Text(" and "), b.Delln(b.Italic("nested"), b.Text(" "), b.Link("link", "x"), b.Code("code")),
+			b.Textln("then ~literal~ text"))},
+		{"strike2", "strike2.md", b.Build(b.Text("a "), b.Strike(""), b.Textln("b"), b.Strikeln(""))},
+
+		// FOOTNOTES
+		{"footnote1", "footnote1.md", b.Build(
+			b.Text("Design"), b.FootnoteRef("1"), b.Text(" and "), b.Italic("perf"), b.FootnoteRef("note"), b.Text(", again"), b.FootnoteRef("1"), b.Textln("."),
+			b.NL(),
+			b.FootnoteDef("note", b.Paragraph(b.Text("Named note with "), b.Bold("bold"), b.Text(".")), b.Paragraph(b.Text("Second paragraph."))),
+			b.Quote(b.Text("Quoted"), b.Footnote(b.Text("Third, with "), b.Code("code"), b.Text("."))),
+			b.FootnoteDef("1", b.Text("First note.")),
+		)},
+
+		// HARD BREAKS
+		{"break2", "break2.md", b.Build(b.Text("line one"), b.HardBreak(), b.Textln("line two  "), b.NL(), b.UL(b.Item(b.Text("item"), b.HardBreak(), b.Textln("next"))))},
+
+		// PARAGRAPHS
+		{"para1", "para1.md", b.Build(b.H1("T"), b.Paragraph(b.Textln("one"), b.Text("two")), b.Paragraph(b.Text("three")), b.UL(b.Item(b.Paragraph(b.Text("a")))), b.Paragraph(b.Text("after")), b.Paragraph(b.Text("code:")), b.IndentedCode("x"))},
+
 		// IMAGE
 		{"img", "img1.md", b.Build(b.Img("alt", "https://google.com/img"))},
 		{"img2", "img2.md", b.Build(b.Img("my-alt", "https://google.com/img"), b.Img("my-alt2", "https://amazon.com/img2"))},
@@ -68,6 +110,36 @@ func TestSimpleBuilderCases(t *testing.T) {
 		{"code1ln", "code1.md", b.Build(b.Codeln("hi"))},
 		{"code2", "code2.md", b.Build(b.Code("hi"), b.Text(","), b.Code("there"))},
 		{"code2ln", "code2.md", b.Build(b.Code("hi"), b.Text(","), b.Codeln("there"))},
+		{"code3", "code3.md", b.Build(b.Code("a`b"), b.Text(" and "), b.Code("`x`"), b.Text(" and "), b.Codeln(`C:\dir\`))},
+
+		// Code fences
+		{"fence1", "fence1.md", b.Build(b.CodeBlock("go", `fmt.Println("hi_there *x*")`))},
+		{"fence3", "fence3.md", b.Build(b.CodeFence("md", "```go\nx := 1\n```"))},
+		{"indented1", "indented1.md", b.Build(b.Textln("text"), b.NL(), b.IndentedCode("x := *y*\n\nz := _w_"), b.NL(), b.Textln("after"))},
+		{"fence4", "fence4.md", b.Build(b.Textln("hi"), b.NL(), b.CodeFence("bash", "go test ./...\n\n# [x](y)\n- not a list"), b.NL(), b.Textln("there"))},
+
+		// Quote
+		{"quote1", "quote1.md", b.Build(b.Quote(b.Text("hi")))},
+		{"quote3", "quote3.md", b.Build(b.Quote(
+			b.Textln("first"),
+			b.Textln("second"),
+			b.NL(),
+			b.UL(b.Textln("a"), b.Textln("b")),
+			b.NL(),
+			b.Quote(b.Textln("nested"), b.Textln("deeper")),
+			b.NL(),
+			b.CodeBlock("go", "x := 1\n\ny := 2"),
+		))},
+		{"quote4", "quote4.md", b.Build(b.Quote(b.Textln("a")), b.NL(), b.Quote(b.Textln("b")))},
+		{"quote5", "quote5.md", b.Build(b.Quote(b.Textln("q")), b.Textln("after"))},
+
+		// Table
+		{"table1", "table1.md", b.Build(b.H1("Routes"), b.NL(), b.Table([]Alignment{AlignLeft, AlignRight, AlignRight, AlignCenter},
+			b.Row(b.Text("Route"), b.Text("Time"), b.Text("Allocs"), b.Text("Note")),
+			b.Row(b.Bold("one-pass"), b.Text("~100 ns/op"), b.Text("3"), b.Code("a|b")),
+			b.Row(b.Text("tokens | pipeline"), b.Text("~1.37 µs/op"), b.Text("12")),
+			b.Row(b.Link("docs", "https://go.dev"), b.Italic("n/a"), b.Text("0"), b.Cell(b.Text("ok"))),
+		), b.Paragraph(b.Text("Text after the table.")))},
 
 		// UL
 		{"ul1", "nl1.md", b.Build(b.UL())},
@@ -79,7 +151,19 @@ func TestSimpleBuilderCases(t *testing.T) {
 		{"ul7", "ul7.md", b.Build(b.UL(b.Textln("one"), b.Textln("two"), b.Textln("three")))},
 		{"ul8", "ul8.md", b.Build(b.UL(b.Textln("one"), b.Text("two "), b.Textln("items"), b.Textln("three")))},
 		{"ul9", "ul9.md", b.Build(b.UL(b.Textln("one"), b.Text("my link: "), b.Linkln("google", "google.com"), b.Textln("three")))},
+		{"ul9items", "ul9.md", b.Build(b.UL(b.Item(b.Textln("one")), b.Item(b.Text("my link: "), b.Linkln("google", "google.com")), b.Item(b.Textln("three"))))},
 		{"ul10", "ul10.md", b.Build(b.UL(b.Textln("one"), b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool"), b.Textln("three")))},
+		{"ul11", "ul11.md", b.Build(b.ULWith('*', b.Textln("star"), b.Textln("items")), b.ULWith('+', b.Textln("plus")))},
+		{"ul12", "ul12.md", b.Build(b.UL(b.Textln("a")), b.Textln("b"))},
+		{"task1", "task1.md", b.Build(b.H1("Release"), b.NL(),
+			b.UL(
+				b.Task(true, b.Text("Tag the "), b.Boldln("release")),
+				b.Task(false, b.Textln("Publish the notes"), b.UL(b.Task(false, b.Textln("on the blog")), b.Task(true, b.Textln("on the list")))),
+				b.Item(b.Textln("[ ] not a task")),
+			),
+			b.NL(),
+			b.OL(b.Task(false, b.Textln("Bump the version"), b.Textln("in go.mod")), b.Task(true, b.Text("Run "), b.Codeln("go test ./..."))),
+		)},
 
 		// // OL
 		{"ol1", "nl1.md", b.Build(b.OL())},
@@ -92,6 +176,10 @@ func TestSimpleBuilderCases(t *testing.T) {
 		{"ol8", "ol8.md", b.Build(b.OL(b.Textln("one"), b.Text("two "), b.Textln("items"), b.Textln("three")))},
 		{"ol9", "ol9.md", b.Build(b.OL(b.Textln("one"), b.Text("my link: "), b.Linkln("google", "google.com"), b.Textln("three")))},
 		{"ol10", "ol10.md", b.Build(b.OL(b.Textln("one"), b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool"), b.Textln("three")))},
+		{"ol10items", "ol10.md", b.Build(b.OL(b.Item(b.Textln("one")), b.Item(b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool")), b.Item(b.Textln("three"))))},
+		{"ol11", "ol11.md", b.Build(b.OL(b.Textln("item 1"), b.Textln("item 2"), b.Textln("item 3"), b.Textln("item 4"), b.Textln("item 5"), b.Textln("item 6"), b.Textln("item 7"), b.Textln("item 8"), b.Textln("item 9"), b.Textln("item 10"), b.Textln("item 11")))},
+		{"ol12", "ol12.md", b.Build(b.OLFrom(7, ')', b.Textln("seven"), b.Textln("eight"), b.Textln("nine"), b.Textln("ten")))},
+		{"ol13", "ol13.md", b.Build(b.OLFrom(0, '.', b.Textln("a"), b.Textln("b")))},
 	}
 
 	for _, tc := range cases {
@@ -276,3 +364,93 @@ func footer(comp string) []*Element {
 		b.Textln(fmt.Sprintf("Copyright %s (c) 2025 Author. All Rights Reserved.", comp)),
 	}
 }
+
+func TestBuilderBullet(t *testing.T) {
+	b := NewBuilder()
+	lists := []*Element{b.ULWith('*', b.Textln("star")), b.ULWith('+', b.Textln("plus")), b.UL(b.Textln("dash"))}
+
+	if got, want := b.Build(lists...), "* star\n+ plus\n- dash\n"; got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+	b.Bullet = '*'
+	if got, want := b.Build(lists...), "* star\n* plus\n* dash\n"; got != want {
+		t.Fatalf("Build with Bullet = %q, want %q", got, want)
+	}
+}
+
+func TestBuilderEmphasis(t *testing.T) {
+	b := NewBuilder()
+	spans := []*Element{
+		b.Bold("a"), b.Text(" "), {Kind: EKBold, Delim: '_', Text: "b"}, b.Text(" "),
+		b.Italic("c"), b.Text(" "), {Kind: EKItalic, Delim: '*', Text: "d"}, b.Text(" "), {Kind: EKItalic, Text: "e"},
+	}
+
+	if got, want := b.Build(spans...), "**a** __b__ _c_ *d* _e_\n"; got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+	b.Emphasis = '*'
+	if got, want := b.Build(spans...), "**a** **b** *c* *d* *e*\n"; got != want {
+		t.Fatalf("Build with Emphasis = %q, want %q", got, want)
+	}
+}
+
+func TestBuilderHTML(t *testing.T) {
+	b := NewBuilder()
+	elements := []*Element{b.HTMLBlock("<div>\n*x*\n</div>"), b.Paragraph(b.Text("a "), b.HTMLInline("<br>"), b.Text(" b"))}
+	cases := []struct {
+		policy HTMLPolicy
+		want   string
+	}{
+		{HTMLPass, "<div>\n*x*\n</div>\n\na <br> b\n"},
+		{HTMLEscape, "\\<div>\n\\*x\\*\n\\</div>\n\na \\<br> b\n"},
+		{HTMLStrip, "a  b\n"},
+	}
+	for _, tc := range cases {
+		b.HTML = tc.policy
+		if got := b.Build(elements...); got != tc.want {
+			t.Errorf("Build with HTML = %d: got %q, want %q", tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestBuildListItems(t *testing.T) {
+	b := NewBuilder()
+	loose := &Element{Kind: EKList, ListKind: ListOrdered, Start: 1, Loose: true, Children: []*Element{
+		b.Item(b.Textln("first"), b.NL(), b.CodeBlock("", "code")),
+		b.Item(b.Textln("second"), b.UL(b.Textln("nested"))),
+	}}
+	want := "1. first\n\n   ```\n   code\n   ```\n\n2. second\n   - nested\n"
+	if got := b.Build(loose); got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+
+	// items and flat children mix in a tight list
+	tight := b.UL(b.Item(b.Textln("a"), b.Textln("b")), b.Textln("c"))
+	if got, want := b.Build(tight), "- a\n  b\n- c\n"; got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+}
+
+func TestBuildFootnotes(t *testing.T) {
+	b := NewBuilder()
+	elements := []*Element{
+		b.FootnoteDef("old", b.Text("never used")),
+		b.FootnoteDef("7", b.Text("seven")),
+		b.Text("a"), b.FootnoteRef("7"), b.Text(" b"), b.Footnote(b.Text("inline"), b.Footnote(b.Text("nested"))),
+		b.Text(" c"), b.FootnoteRef("gone"), b.FootnoteRef("7"),
+		b.FootnoteDef("9", b.Text("nine")),
+	}
+	// numbered in order of first use, the footnotes of footnotes after those of the document
+	want := "a[^1] b[^2] c[^gone][^1]\n\n[^1]: seven\n[^2]: inline[^3]\n[^3]: nested\n[^old]: never used\n[^4]: nine\n"
+	if got := b.Build(elements...); got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+
+	missing, unused := b.CheckFootnotes(elements...)
+	if diff := cmp.Diff([]string{"gone"}, missing); diff != "" {
+		t.Errorf("missing mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"old", "9"}, unused); diff != "" {
+		t.Errorf("unused mismatch (-want +got):\n%s", diff)
+	}
+}
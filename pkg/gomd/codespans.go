@@ -0,0 +1,73 @@
+package gomd
+
+import "strings"
+
+// backtickRun returns the number of backticks at the start of s.
+func backtickRun(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// parseCodeSpan parses the code span at the start of s, opened by a run of backticks and closed by the next run
+// of the same length. It returns the code and the number of bytes of s taken, or 0 if the run is never closed,
+// in which case the whole run is literal text. Backslashes are literal inside code spans.
+func parseCodeSpan(s string) (string, int) {
+	open := backtickRun(s)
+	for i := open; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := backtickRun(s[i:])
+		if run == open {
+			return codeSpanContent(s[open:i]), i + run
+		}
+		i += run
+	}
+	return "", 0
+}
+
+// codeSpanContent returns the code between the backticks of a code span: line endings become spaces, and a single
+// space is stripped from both ends when there is one at both ends, unless the code is only spaces.
+func codeSpanContent(code string) string {
+	code = strings.ReplaceAll(code, "\n", " ")
+	if isPadded(code) {
+		return code[1 : len(code)-1]
+	}
+	return code
+}
+
+// isPadded checks if the code of a code span has a space at both ends that is stripped, as it isn't only spaces.
+func isPadded(code string) bool {
+	return len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && !onlySpaces(code)
+}
+
+// codeSpan returns the markdown for a code span holding code. The fence is the shortest run of backticks that doesn't
+// appear in the code, and the code is padded with a space on both ends when it starts or ends with a backtick,
+// or when a space at both ends would otherwise be stripped.
+func codeSpan(code string) string {
+	fence := 1
+	for containsRun(code, fence) {
+		fence++
+	}
+	ticks := strings.Repeat("`", fence)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") || isPadded(code) {
+		return ticks + " " + code + " " + ticks
+	}
+	return ticks + code + ticks
+}
+
+// containsRun checks if s holds a run of exactly n backticks.
+func containsRun(s string, n int) bool {
+	for i := 0; i < len(s); {
+		run := backtickRun(s[i:])
+		if run == n {
+			return true
+		}
+		i += max(run, 1)
+	}
+	return false
+}
@@ -9,11 +9,11 @@ func (c *Compounder) section(h func(string) *Element, title string, paras ...str
 		out = append(out, c.Builder.NL())
 	}
 
+	// Build separates the paragraphs, only the end of the section gets an NL, as the other parts of a Compound do
 	for _, p := range paras {
-		out = append(out, c.Builder.Textln(p), c.Builder.NL())
+		out = append(out, c.Builder.Paragraph(c.Builder.Text(p)))
 	}
-
-	return out
+	return append(out, c.Builder.NL())
 }
 
 // Section1 is used to render a markdown section with a H1 header and paragraphs of text.
@@ -94,11 +94,11 @@ func (c *Compounder) Header6(text string) []*Element {
 	return []*Element{c.Builder.H6(text), c.Builder.NL()}
 }
 
-// appendChildrenLines is a helper function that appends lines of text as children elements.
+// appendChildrenLines is a helper function that appends lines of text as list items.
 func (c *Compounder) appendChildrenLines(texts []string) []*Element {
 	children := []*Element{}
 	for _, text := range texts {
-		children = append(children, c.Builder.Textln(text))
+		children = append(children, c.Builder.Item(c.Builder.Paragraph(c.Builder.Text(text))))
 	}
 	return children
 }
@@ -20,11 +20,24 @@ func _() {
 	_ = x[EKImage-9]
 	_ = x[EKList-10]
 	_ = x[EKQuote-11]
+	_ = x[EKLinkDef-12]
+	_ = x[EKRaw-13]
+	_ = x[EKListItem-14]
+	_ = x[EKHardBreak-15]
+	_ = x[EKParagraph-16]
+	_ = x[EKHTMLBlock-17]
+	_ = x[EKHTMLInline-18]
+	_ = x[EKTable-19]
+	_ = x[EKTableRow-20]
+	_ = x[EKTableCell-21]
+	_ = x[EKStrike-22]
+	_ = x[EKFootnoteRef-23]
+	_ = x[EKFootnoteDef-24]
 }
 
-const _ElementKind_name = "EKHeadingEKTextEKBoldEKItalicEKCodeSpanEKCodeBlockEKNewLineEKRuleEKLinkEKImageEKListEKQuote"
+const _ElementKind_name = "EKHeadingEKTextEKBoldEKItalicEKCodeSpanEKCodeBlockEKNewLineEKRuleEKLinkEKImageEKListEKQuoteEKLinkDefEKRawEKListItemEKHardBreakEKParagraphEKHTMLBlockEKHTMLInlineEKTableEKTableRowEKTableCellEKStrikeEKFootnoteRefEKFootnoteDef"
 
-var _ElementKind_index = [...]uint8{0, 9, 15, 21, 29, 39, 50, 59, 65, 71, 78, 84, 91}
+var _ElementKind_index = [...]uint8{0, 9, 15, 21, 29, 39, 50, 59, 65, 71, 78, 84, 91, 100, 105, 115, 126, 137, 148, 160, 167, 177, 188, 196, 209, 222}
 
 func (i ElementKind) String() string {
 	if i >= ElementKind(len(_ElementKind_index)-1) {
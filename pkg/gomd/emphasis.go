@@ -0,0 +1,226 @@
+package gomd
+
+import (
+	"strings"
+	"unicode"
+)
+
+// delimRun is a run of '*' or '_' characters in inline text, which may open or close bold and italic text,
+// or a run of '~' characters, which may open or close struck text.
+// Its characters are held by a text element among the parsed inline elements until the run is matched.
+type delimRun struct {
+	el       *Element
+	char     byte
+	length   int // the characters of the run that are left
+	orig     int // the length of the run as written
+	pos      int // the index of el among the elements, while they are resolved
+	canOpen  bool
+	canClose bool
+	removed  bool
+}
+
+// newDelimRun builds the delimiter run of length chars, with the characters before and after it
+// (a space at the start or end of the text), following the left- and right-flanking rules of CommonMark.
+// An underscore run can't open or close emphasis inside a word. As in GFM, only a run of one or two tildes
+// can open or close struck text.
+func newDelimRun(char byte, length int, before, after rune) *delimRun {
+	d := &delimRun{el: &Element{Kind: EKText, Text: strings.Repeat(string(char), length)}, char: char, length: length, orig: length}
+	d.canOpen, d.canClose = flanking(char, length, before, after)
+	return d
+}
+
+// flanking reports whether a delimiter run of length chars, between before and after, can open and close
+// emphasis or struck text, as newDelimRun sets them.
+func flanking(char byte, length int, before, after rune) (canOpen, canClose bool) {
+	left := !unicode.IsSpace(after) && (!isPunctRune(after) || unicode.IsSpace(before) || isPunctRune(before))
+	right := !unicode.IsSpace(before) && (!isPunctRune(before) || unicode.IsSpace(after) || isPunctRune(after))
+	switch {
+	case char == '_':
+		return left && (!right || isPunctRune(before)), right && (!left || isPunctRune(after))
+	case char == '~' && length > 2:
+		// a longer run of tildes is literal
+		return false, false
+	}
+	return left, right
+}
+
+// isPunctRune checks if r is a Unicode punctuation or symbol character.
+func isPunctRune(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }
+
+// matches checks if the run can close emphasis opened by the opener. Following the "rule of 3", a run that can both
+// open and close can't match a run whose combined length is a multiple of 3, unless both lengths are.
+// Tilde runs only match a run of the same length.
+func (d *delimRun) matches(opener *delimRun) bool {
+	if opener.removed || opener.length == 0 || !opener.canOpen || opener.char != d.char {
+		return false
+	}
+	if d.char == '~' {
+		return opener.length == d.length
+	}
+	if (opener.canClose || d.canOpen) && (opener.orig+d.orig)%3 == 0 {
+		return opener.orig%3 == 0 && d.orig%3 == 0
+	}
+	return true
+}
+
+// resolveEmphasis matches the delimiter runs among the parsed inline elements, as in the "process emphasis" procedure
+// of CommonMark: each closer is matched with the nearest opener before it, and the elements in between become the
+// Children of a bold element when both runs have two characters left, or an italic one. The delimiter is recorded
+// in Delim. Tilde runs make struck text, their tildes are recorded in Fence. Characters left unmatched stay text.
+// The elements and the runs are kept in linked lists, so a span is made without moving the elements after it, and
+// openersBottom bounds the search for an opener so that runs which can't match aren't searched again.
+func resolveEmphasis(elements []*Element, runs []*delimRun) []*Element {
+	if len(runs) == 0 {
+		return mergeText(elements)
+	}
+
+	// next links the elements in order, -1 ends them; the runs are in the order of their elements
+	next := make([]int, len(elements))
+	for i, r := 0, 0; i < len(elements); i++ {
+		next[i] = i + 1
+		if r < len(runs) && runs[r].el == elements[i] {
+			runs[r].pos = i
+			r++
+		}
+	}
+	next[len(elements)-1] = -1
+
+	// prev and after link the runs that can still be matched
+	prev, after := make([]int, len(runs)), make([]int, len(runs))
+	for i := range runs {
+		prev[i], after[i] = i-1, i+1
+	}
+	after[len(runs)-1] = -1
+	unlink := func(i int) {
+		runs[i].removed = true
+		if prev[i] >= 0 {
+			after[prev[i]] = after[i]
+		}
+		if after[i] >= 0 {
+			prev[after[i]] = prev[i]
+		}
+	}
+
+	openersBottom := map[int]int{} // by bottomKey, the run at or before which no opener can match
+	for c := 0; c < len(runs); c++ {
+		closer := runs[c]
+		if !closer.canClose || closer.removed || closer.length == 0 {
+			continue
+		}
+
+		bottom, ok := openersBottom[closer.bottomKey()]
+		if !ok {
+			bottom = -1
+		}
+		o := prev[c]
+		for o > bottom && !closer.matches(runs[o]) {
+			o = prev[o]
+		}
+		if o <= bottom {
+			openersBottom[closer.bottomKey()] = prev[c]
+			if !closer.canOpen {
+				unlink(c)
+			}
+			continue
+		}
+		opener := runs[o]
+
+		kind, n := EKItalic, 1
+		switch {
+		case closer.char == '~':
+			kind, n = EKStrike, closer.length
+		case opener.length >= 2 && closer.length >= 2:
+			kind, n = EKBold, 2
+		}
+		opener.length -= n
+		closer.length -= n
+		opener.el.Text = opener.el.Text[n:]
+		closer.el.Text = closer.el.Text[n:]
+
+		var inner []*Element
+		for i := next[opener.pos]; i != closer.pos; i = next[i] {
+			inner = append(inner, elements[i])
+		}
+		span := spanElement(kind, mergeText(inner))
+		if kind == EKStrike {
+			span.Fence = strings.Repeat("~", n)
+		} else {
+			span.Delim = closer.char
+		}
+		elements = append(elements, span)
+		next = append(next, closer.pos)
+		next[opener.pos] = len(elements) - 1
+
+		// the runs in between are inside the span now, they stay text
+		for i := after[o]; i != c; i = after[i] {
+			runs[i].removed = true
+		}
+		after[o], prev[c] = c, o
+		if opener.length == 0 {
+			unlink(o)
+		}
+		if closer.length > 0 {
+			c-- // the rest of the closer may close another opener
+		} else {
+			unlink(c)
+		}
+	}
+
+	var out []*Element
+	for i := 0; i >= 0; i = next[i] {
+		out = append(out, elements[i])
+	}
+	return mergeText(out)
+}
+
+// bottomKey returns the key of a closer among the openers bottoms of resolveEmphasis: its character, whether it can
+// open too and its length modulo 3, which decide the openers it can match. A tilde run only matches one of its length.
+func (d *delimRun) bottomKey() int {
+	n := d.orig % 3
+	if d.char == '~' {
+		n = d.length
+	}
+	return int(d.char)<<16 | n<<1 | btoi(d.canOpen)
+}
+
+// mergeText joins adjacent text elements and drops empty ones, such as the runs whose characters were all used, and
+// the space the renderer puts after a link. The elements are merged in place.
+func mergeText(elements []*Element) []*Element {
+	out := elements[:0]
+	for i := 0; i < len(elements); {
+		if !isMergeableText(elements[i]) {
+			out = append(out, elements[i])
+			i++
+			continue
+		}
+		var b strings.Builder
+		var last *Element // the only non-empty text of the run, if there is only one
+		texts := 0
+		for ; i < len(elements) && isMergeableText(elements[i]); i++ {
+			if elements[i].Text != "" {
+				b.WriteString(elements[i].Text)
+				last = elements[i]
+				texts++
+			}
+		}
+		switch {
+		case texts == 1:
+			out = append(out, last)
+		case texts > 1:
+			out = append(out, &Element{Kind: EKText, Text: b.String()})
+		}
+	}
+
+	// the single space between a link and the element after it is written by the renderer
+	for i := len(out) - 2; i > 0; i-- {
+		if el := out[i]; el.Kind == EKText && el.Text == " " && !el.LineBreak && linkSpace(out[i-1], out[i+1]) {
+			out = append(out[:i], out[i+1:]...)
+		}
+	}
+	return out
+}
+
+// isMergeableText checks if el is plain text that mergeText can join with the text around it.
+func isMergeableText(el *Element) bool {
+	return el.Kind == EKText && len(el.Children) == 0 && !el.LineBreak
+}
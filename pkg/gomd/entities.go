@@ -0,0 +1,57 @@
+package gomd
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEntityLen is the length of the longest entity reference, "&CounterClockwiseContourIntegral;".
+const maxEntityLen = 33
+
+// parseEntity parses the entity or numeric character reference at the start of s: a named reference of the HTML5
+// table such as "&amp;" or "&copy;", a decimal one such as "&#169;" or a hexadecimal one such as "&#xA9;".
+// It returns the text the reference stands for and the number of bytes of s it spans, or 0 if s doesn't start with one.
+// Unlike in HTML, the closing semicolon is required.
+func parseEntity(s string) (string, int) {
+	if !strings.HasPrefix(s, "&") {
+		return "", 0
+	}
+	end := strings.IndexByte(s, ';')
+	if end < 2 || end >= maxEntityLen {
+		return "", 0
+	}
+	ref := s[:end+1]
+
+	if ref[1] != '#' {
+		if !isASCIILetter(ref[1]) || !isAlnum(ref[1:end]) {
+			return "", 0
+		}
+		// the html package holds the full table, an unknown name is left as it is. It also decodes legacy names
+		// without a semicolon, so "&ampx;" becomes "&x;", which isn't a reference either.
+		text := html.UnescapeString(ref)
+		if n := len(text); text == ref || (n >= 2 && text[n-1] == ';' && isAlnum(text[n-2:n-1])) {
+			return "", 0
+		}
+		return text, end + 1
+	}
+
+	digits, base, max := ref[2:end], 10, 7
+	if strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X") {
+		digits, base, max = digits[1:], 16, 6
+	}
+	if digits == "" || len(digits) > max {
+		return "", 0
+	}
+	code, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return "", 0
+	}
+	// U+0000 and code points that aren't valid characters are replaced
+	r := rune(code)
+	if r == 0 || !utf8.ValidRune(r) {
+		r = utf8.RuneError
+	}
+	return string(r), end + 1
+}
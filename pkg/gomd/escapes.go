@@ -1,6 +1,9 @@
 package gomd
 
-import "strings"
+import (
+	"strings"
+	"unicode/utf8"
+)
 
 var inlineReplacer = strings.NewReplacer(
 	"*", "\\*", "_", "\\_",
@@ -12,14 +15,210 @@ var inlineReplacer = strings.NewReplacer(
 // escapeInline escapes characters that have special meaning in inline markdown syntax.
 func escapeInline(s string) string { return inlineReplacer.Replace(s) }
 
-// escapeBackticks escapes backticks in a string, which is useful for inline code blocks.
-func escapeBackticks(s string) string { return strings.ReplaceAll(s, "`", "\\`") }
-
-// escapeLinkText escapes characters in link text that have special meaning in markdown.
-func escapeLinkText(s string) string { return escapeInline(s) }
+// escapeLinkText escapes the literal text of a link or the alt text of an image, brackets always need escaping there.
+func escapeLinkText(s string) string { return escapeSpanText(s, "[]") }
 
 // escapeURL escapes spaces and parentheses in URLs, which is useful for markdown links.
+// An '&' that would start an entity reference is backslash-escaped.
 func escapeURL(u string) string {
 	r := strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
-	return r.Replace(u)
+	return escapeEntities(r.Replace(u))
+}
+
+// escapeEntities backslash-escapes every '&' of s that would be read as the start of an entity or numeric
+// character reference, a bare '&' is left as it is.
+func escapeEntities(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if _, n := parseEntity(s[i:]); n > 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isASCIIPunct checks if c is one of the ASCII punctuation characters that can be backslash-escaped.
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+// unescapeText decodes the backslash escapes and the entity and numeric character references in s.
+// A backslash before ASCII punctuation makes it literal, so "\\&amp;" stays "&amp;".
+func unescapeText(s string) string {
+	if !strings.ContainsAny(s, "\\&") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if text, n := parseEntity(s[i:]); n > 0 {
+			b.WriteString(text)
+			i += n - 1
+			continue
+		}
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escapeText escapes literal text for rendering, but only if it would otherwise not read back as the same text:
+// it holds backslashes that would escape something, or characters that would be parsed as markup.
+// At the start of a line, text that would open a block such as a heading, quote or list item is escaped too.
+func escapeText(s string, lineStart bool) string {
+	if needsEscape(s) {
+		s = escapeMarkup(s)
+	}
+	if lineStart && opensBlock(s) {
+		return escapeLineStart(s)
+	}
+	return s
+}
+
+// escapeSpanText escapes the literal text inside delimiters, such as the text of bold or italic elements.
+// Unlike escapeText it also escapes when the text holds any of the delimiters, as they could close the span early.
+func escapeSpanText(s, delims string) string {
+	if needsEscape(s) || strings.ContainsAny(s, delims) {
+		return escapeMarkup(s)
+	}
+	return s
+}
+
+// needsEscape checks if literal text would not read back as the same text. It looks at the bytes that can start
+// markup rather than parsing s: a backslash that would escape something, a run of '*', '_' or '~' that can close
+// a span opened by an earlier run, a backtick run closed by another of the same length, a bracket followed by a link destination,
+// a '<' that can open an autolink or an HTML tag, or an entity reference that would be decoded.
+func needsEscape(s string) bool {
+	lastGT := strings.LastIndexByte(s, '>')
+	opened := map[int]bool{} // the delimiter runs that can open a span, by delimKey
+	var ticks []int          // the lengths of the backtick runs of s, in order
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 == len(s) || isASCIIPunct(s[i+1]) {
+				return true
+			}
+		case '*', '_', '~':
+			n := 1
+			for i+n < len(s) && s[i+n] == c {
+				n++
+			}
+			before, after := ' ', ' '
+			if i > 0 {
+				before, _ = utf8.DecodeLastRuneInString(s[:i])
+			}
+			if i+n < len(s) {
+				after, _ = utf8.DecodeRuneInString(s[i+n:])
+			}
+			open, close := flanking(c, n, before, after)
+			if close && opened[delimKey(c, n)] {
+				return true
+			}
+			if open {
+				opened[delimKey(c, n)] = true
+			}
+			i += n - 1
+		case '`':
+			n := backtickRun(s[i:])
+			ticks = append(ticks, n)
+			i += n - 1
+		case ']':
+			if i+1 < len(s) && s[i+1] == '(' {
+				return true
+			}
+		case '<':
+			if i+1 < len(s) && (isASCIILetter(s[i+1]) || strings.IndexByte("/!?", s[i+1]) >= 0) && i < lastGT {
+				return true
+			}
+		case '&':
+			if _, n := parseEntity(s[i:]); n > 0 {
+				return true
+			}
+		}
+	}
+
+	// a backtick run opens a code span if a later run has the same length
+	later := map[int]bool{}
+	for i := len(ticks) - 1; i >= 0; i-- {
+		if later[ticks[i]] {
+			return true
+		}
+		later[ticks[i]] = true
+	}
+	return false
+}
+
+// delimKey returns the key of a delimiter run of length chars among the runs that can open a span: any run of '*'
+// or '_' can close another of the same character, while a run of tildes only closes one of the same length.
+func delimKey(char byte, length int) int {
+	if char == '~' {
+		return int(char)<<8 | length
+	}
+	return int(char) << 8
+}
+
+// escapeMarkup escapes every character of s that can open or close inline markup, backslashes that would escape
+// and the '&' of entity references.
+func escapeMarkup(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		_, entity := parseEntity(s[i:])
+		if strings.IndexByte("*_~`[]<", c) >= 0 || (c == '\\' && (i+1 == len(s) || isASCIIPunct(s[i+1]))) || entity > 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// escapeHTML escapes raw HTML so that it reads back as literal text, line by line.
+func escapeHTML(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = escapeMarkup(line)
+		if opensBlock(lines[i]) {
+			lines[i] = escapeLineStart(lines[i])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// opensBlock checks if a line of text would be read as the start of a block other than a paragraph, including an
+// indented code block or a link reference definition. A table delimiter row counts too, as it would turn the line
+// above it into the header of a table.
+func opensBlock(s string) bool {
+	if onlySpaces(s) {
+		return false
+	}
+	_, code := stripCodeIndent(s)
+	_, def := parseLinkDef(s)
+	_, delims := parseTableDelims(s)
+	return code || def || delims || startsBlock(s) || setextLevel(s) > 0
+}
+
+// escapeLineStart escapes the marker that makes a line open a block: "1\. item", "\# title", "\- item",
+// "\[label]: href" or "\| --- |". Indentation that would open a code block can't be escaped, it is dropped as a
+// paragraph drops it.
+func escapeLineStart(s string) string {
+	if _, code := stripCodeIndent(s); code {
+		if s = strings.TrimLeft(s, " \t"); !opensBlock(s) {
+			return s
+		}
+	}
+	_, rest := leadingIndent(s)
+	indent := s[:len(s)-len(rest)]
+	digits := 0
+	for digits < len(rest) && isASCIIDigit(rest[digits]) {
+		digits++
+	}
+	if digits > 0 {
+		return indent + rest[:digits] + "\\" + rest[digits:]
+	}
+	return indent + "\\" + rest
 }
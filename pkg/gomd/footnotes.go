@@ -0,0 +1,173 @@
+package gomd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// isFootnoteLabel checks if the label of a footnote, without its '^', is valid: not empty, and without whitespace
+// or brackets.
+func isFootnoteLabel(label string) bool {
+	return label != "" && !strings.ContainsAny(label, " \t\n[]")
+}
+
+// parseFootnoteRef parses the footnote reference "[^label]" at the start of s. It returns the label and the number
+// of bytes of s taken, or 0.
+func parseFootnoteRef(s string) (string, int) {
+	if !strings.HasPrefix(s, "[^") {
+		return "", 0
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 || !isFootnoteLabel(s[2:end]) {
+		return "", 0
+	}
+	return s[2:end], end + 1
+}
+
+// parseFootnoteDef checks if the line opens a footnote definition "[^label]: text", after up to 3 spaces of indent.
+// It returns the label and the text of its first line.
+func parseFootnoteDef(line string) (label, text string, ok bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 {
+		return "", "", false
+	}
+	label, n := parseFootnoteRef(rest)
+	if n == 0 || n >= len(rest) || rest[n] != ':' {
+		return "", "", false
+	}
+	return label, strings.TrimLeft(rest[n+1:], " \t"), true
+}
+
+// footnoteKey returns the key of a footnote label among the definitions of a Document.
+func footnoteKey(label string) string { return "^" + normalizeLabel(label) }
+
+// footnoteLines finds the lines of the footnote definition opened by the line at i, whose text is first: lines
+// indented by four columns, blank lines followed by such lines and lazy continuation lines of its paragraph.
+// It returns the lines, stripped of their indentation, and the index after the last one.
+func footnoteLines(lines []string, i int, first string) ([]string, int) {
+	end := itemContinuation(lines, i, first, 4)
+	inner := []string{first}
+	for _, line := range lines[i+1 : end] {
+		inner = append(inner, stripIndent(line, 4))
+	}
+	return inner, end
+}
+
+// linkFootnotes points the footnote labels of defs, collected before parsing, at the first definition of each label
+// among the parsed elements, so that the definitions of a Document hold the content of its footnotes.
+func linkFootnotes(defs map[string]*Element, elements []*Element) {
+	seen := map[string]bool{}
+	walkBlocks(elements, func(el *Element) {
+		if el.Kind != EKFootnoteDef {
+			return
+		}
+		if key := footnoteKey(el.Ref); !seen[key] {
+			seen[key] = true
+			defs[key] = el
+		}
+	})
+}
+
+// footnotes holds the footnotes of a document as Build writes them: numbered in order of first use, with their
+// definitions collected at the end of the document.
+type footnotes struct {
+	labels  map[*Element]string // the label each reference is written with
+	defs    []*Element          // the definitions to write, in order of first use, then those never used
+	missing []string            // the labels of references without a definition
+	unused  []string            // the labels of definitions without a reference
+}
+
+// collectFootnotes finds the footnotes of the elements. The footnotes attached to their reference by the Builder
+// and those labeled with a number are numbered in order of first use, counting the references inside footnotes after
+// those of the document. Other labels are kept as they are and take no number.
+// Definitions nothing refers to are written after the others, numbered on if their label is a number.
+func collectFootnotes(elements []*Element) *footnotes {
+	defined := map[string]*Element{}
+	var order []string
+	Walk(elements, func(el *Element) {
+		if key := footnoteKey(el.Ref); el.Kind == EKFootnoteDef && defined[key] == nil {
+			defined[key] = el
+			order = append(order, key)
+		}
+	})
+
+	notes := &footnotes{labels: map[*Element]string{}}
+	used := map[string]string{} // key of a labeled footnote -> label written
+	n := 0
+	number := func(label string) string {
+		if label == "" || isNumber(label) {
+			n++
+			return strconv.Itoa(n)
+		}
+		return label
+	}
+
+	var visit func(elements []*Element)
+	visit = func(elements []*Element) {
+		for _, el := range elements {
+			switch {
+			case el == nil, el.Kind == EKFootnoteDef:
+			case el.Kind == EKFootnoteRef && len(el.Children) > 0:
+				// the content of the footnote is attached to its reference
+				label := number(el.Ref)
+				notes.labels[el] = label
+				notes.defs = append(notes.defs, &Element{Kind: EKFootnoteDef, Ref: label, Children: el.Children, LineBreak: true})
+			case el.Kind == EKFootnoteRef:
+				key := footnoteKey(el.Ref)
+				label, ok := used[key]
+				if !ok {
+					label = number(el.Ref)
+					used[key] = label
+					if def := defined[key]; def != nil {
+						notes.defs = append(notes.defs, &Element{Kind: EKFootnoteDef, Ref: label, Children: def.Children, LineBreak: true})
+					} else {
+						notes.missing = append(notes.missing, el.Ref)
+					}
+				}
+				notes.labels[el] = label
+			default:
+				visit(el.Children)
+			}
+		}
+	}
+	visit(elements)
+	for i := 0; i < len(notes.defs); i++ {
+		visit(notes.defs[i].Children)
+	}
+
+	for _, key := range order {
+		if _, ok := used[key]; !ok {
+			def := defined[key]
+			notes.unused = append(notes.unused, def.Ref)
+			notes.defs = append(notes.defs, &Element{Kind: EKFootnoteDef, Ref: number(def.Ref), Children: def.Children, LineBreak: true})
+		}
+	}
+	return notes
+}
+
+// isNumber checks if s is made of ASCII digits only.
+func isNumber(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// footnoteLabel returns the label a footnote reference is written with, or its label as it is outside of Build.
+func (ctx *renderCtx) footnoteLabel(el *Element) string {
+	if ctx.notes != nil {
+		if label, ok := ctx.notes.labels[el]; ok {
+			return label
+		}
+	}
+	return el.Ref
+}
+
+// footnoteDefinition returns the markdown for a footnote definition: its label, then its content, with every line
+// after the first indented by four spaces.
+func (ctx *renderCtx) footnoteDefinition(b *Builder, el *Element) string {
+	lines := strings.Split(ctx.renderBlocks(b, el.Children), "\n")
+	for i, line := range lines {
+		if i > 0 && line != "" {
+			lines[i] = "    " + line
+		}
+	}
+	return strings.TrimRight("[^"+el.Ref+"]: "+strings.Join(lines, "\n"), " ")
+}
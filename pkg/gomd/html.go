@@ -0,0 +1,199 @@
+package gomd
+
+import "strings"
+
+// htmlRawTags are the tags whose content is never markdown, they open an HTML block that runs to their closing tag.
+var htmlRawTags = []string{"pre", "script", "style", "textarea"}
+
+// htmlBlockTags are the tags that open an HTML block running to the next blank line, even inside a paragraph.
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "basefont": true, "blockquote": true,
+	"body": true, "caption": true, "center": true, "col": true, "colgroup": true, "dd": true, "details": true,
+	"dialog": true, "dir": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "frame": true, "frameset": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "head": true, "header": true, "hr": true, "html": true,
+	"iframe": true, "legend": true, "li": true, "link": true, "main": true, "menu": true, "menuitem": true,
+	"nav": true, "noframes": true, "ol": true, "optgroup": true, "option": true, "p": true, "param": true,
+	"search": true, "section": true, "summary": true, "table": true, "tbody": true, "td": true, "tfoot": true,
+	"th": true, "thead": true, "title": true, "tr": true, "track": true, "ul": true,
+}
+
+// htmlBlockStart returns the CommonMark start condition, 1 to 7, of the HTML block opened by the line, or 0:
+//  1. <pre, <script, <style or <textarea, up to their closing tag
+//  2. a comment "<!--", up to "-->"
+//  3. a processing instruction "<?", up to "?>"
+//  4. a declaration "<!" and a letter, up to ">"
+//  5. "<![CDATA[", up to "]]>"
+//  6. an opening or closing tag of a block element such as <div> or </p>, up to a blank line
+//  7. a line holding nothing but a complete opening or closing tag, up to a blank line
+//
+// A block of condition 7 can't interrupt a paragraph, so it is only returned if afterPara is false.
+func htmlBlockStart(line string, afterPara bool) int {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || !strings.HasPrefix(rest, "<") {
+		return 0
+	}
+	lower := strings.ToLower(rest)
+	for _, tag := range htmlRawTags {
+		if after := strings.TrimPrefix(lower, "<"+tag); len(after) < len(lower) && (after == "" || strings.IndexByte(" \t>", after[0]) >= 0) {
+			return 1
+		}
+	}
+	switch {
+	case strings.HasPrefix(rest, "<!--"):
+		return 2
+	case strings.HasPrefix(rest, "<?"):
+		return 3
+	case strings.HasPrefix(rest, "<![CDATA["):
+		return 5
+	case strings.HasPrefix(rest, "<!") && len(rest) > 2 && isASCIILetter(rest[2]):
+		return 4
+	}
+
+	name := strings.TrimPrefix(lower[1:], "/")
+	n := tagNameLen(name)
+	if after := name[n:]; n > 0 && htmlBlockTags[name[:n]] &&
+		(after == "" || strings.IndexByte(" \t>", after[0]) >= 0 || strings.HasPrefix(after, "/>")) {
+		return 6
+	}
+	if n := parseHTMLTag(rest); !afterPara && n > 0 && onlySpaces(rest[n:]) {
+		return 7
+	}
+	return 0
+}
+
+// htmlBlockEnds checks if the line ends an HTML block of the start condition, the line being the last of the block.
+// Blocks of conditions 6 and 7 end before a blank line instead.
+func htmlBlockEnds(cond int, line string) bool {
+	switch cond {
+	case 1:
+		lower := strings.ToLower(line)
+		for _, tag := range htmlRawTags {
+			if strings.Contains(lower, "</"+tag+">") {
+				return true
+			}
+		}
+	case 2:
+		return strings.Contains(line, "-->")
+	case 3:
+		return strings.Contains(line, "?>")
+	case 4:
+		return strings.Contains(line, ">")
+	case 5:
+		return strings.Contains(line, "]]>")
+	}
+	return false
+}
+
+// parseHTMLTag parses the inline HTML at the start of s: an opening or closing tag, a comment, a processing
+// instruction, a declaration or a CDATA section. It returns the number of bytes of s it spans, or 0.
+func parseHTMLTag(s string) int {
+	switch {
+	case strings.HasPrefix(s, "<!-->"):
+		return len("<!-->")
+	case strings.HasPrefix(s, "<!--->"):
+		return len("<!--->")
+	case strings.HasPrefix(s, "<!--"):
+		return spanTo(s, len("<!--"), "-->")
+	case strings.HasPrefix(s, "<?"):
+		return spanTo(s, len("<?"), "?>")
+	case strings.HasPrefix(s, "<![CDATA["):
+		return spanTo(s, len("<![CDATA["), "]]>")
+	case strings.HasPrefix(s, "<!") && len(s) > 2 && isASCIILetter(s[2]):
+		return spanTo(s, 2, ">")
+	case strings.HasPrefix(s, "</"):
+		n := tagNameLen(s[2:])
+		if n == 0 {
+			return 0
+		}
+		if i := skipHTMLSpace(s, 2+n); i < len(s) && s[i] == '>' {
+			return i + 1
+		}
+		return 0
+	case strings.HasPrefix(s, "<"):
+		n := tagNameLen(s[1:])
+		if n == 0 {
+			return 0
+		}
+		// attributes are separated by whitespace
+		i := 1 + n
+		for {
+			j := skipHTMLSpace(s, i)
+			a := attributeLen(s[j:])
+			if j == i || a == 0 {
+				i = j
+				break
+			}
+			i = j + a
+		}
+		if strings.HasPrefix(s[i:], "/>") {
+			return i + 2
+		}
+		if i < len(s) && s[i] == '>' {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// spanTo returns the length of s up to and including the first end after from, or 0 if there is none.
+func spanTo(s string, from int, end string) int {
+	if i := strings.Index(s[from:], end); i >= 0 {
+		return from + i + len(end)
+	}
+	return 0
+}
+
+// tagNameLen returns the length of the tag name at the start of s: an ASCII letter, then letters, digits or '-'.
+func tagNameLen(s string) int {
+	if s == "" || !isASCIILetter(s[0]) {
+		return 0
+	}
+	n := 1
+	for n < len(s) && (isASCIILetter(s[n]) || isASCIIDigit(s[n]) || s[n] == '-') {
+		n++
+	}
+	return n
+}
+
+// attributeLen returns the length of the attribute at the start of s, a name with an optional "=value", or 0.
+// The value may be unquoted, single-quoted or double-quoted.
+func attributeLen(s string) int {
+	if s == "" || !(isASCIILetter(s[0]) || s[0] == '_' || s[0] == ':') {
+		return 0
+	}
+	n := 1
+	for n < len(s) && (isASCIILetter(s[n]) || isASCIIDigit(s[n]) || strings.IndexByte("_.:-", s[n]) >= 0) {
+		n++
+	}
+	j := skipHTMLSpace(s, n)
+	if j >= len(s) || s[j] != '=' {
+		return n
+	}
+	j = skipHTMLSpace(s, j+1)
+	if j >= len(s) {
+		return n
+	}
+	if q := s[j]; q == '"' || q == '\'' {
+		if end := strings.IndexByte(s[j+1:], q); end >= 0 {
+			return j + end + 2
+		}
+		return n
+	}
+	v := j
+	for v < len(s) && strings.IndexByte(" \t\n\"'=<>`", s[v]) < 0 {
+		v++
+	}
+	if v == j {
+		return n
+	}
+	return v
+}
+
+// skipHTMLSpace returns the index of the first byte of s at or after i that isn't a space, tab or newline.
+func skipHTMLSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
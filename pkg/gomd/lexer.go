@@ -147,10 +147,50 @@ func (l *Lexer) TokenizeCtx(ctx context.Context, r io.Reader) ([]Token, error) {
 			continue
 		}
 
+		// lex a blockquote marker at BOL (after <=3 spaces). We stay at line start afterwards,
+		// so nested "> >" markers and list markers inside the quote are lexed too.
+		if atLineStart && indent <= 3 && ch == '>' {
+			emitText()
+			tokens = append(tokens, Token{Kind: TQuoteMarker, Lexeme: ">", Pos: Pos{line, col}})
+			indent = 0
+			continue
+		}
+
+		// a '+' bullet stays text, but the indentation of up to 3 spaces before it is a text token of its own, as it is
+		// in front of the other list markers
+		if atLineStart && indent <= 3 && ch == '+' {
+			emitText()
+		}
+
 		// from here, we’re not at line-start anymore.
 		atLineStart = false
 		indent = 0
 
+		// a backslash escape stays text with the punctuation it escapes, so it never becomes a token of its own
+		if ch == '\\' {
+			buf.WriteRune(ch)
+			if next, ok2, _ := readRune(); ok2 {
+				if next < unicode.MaxASCII && isASCIIPunct(byte(next)) {
+					buf.WriteRune(next)
+				} else {
+					unreadRune()
+				}
+			}
+			continue
+		}
+
+		// an entity reference such as "&#169;" stays text too, its '#' isn't a token
+		if ch == '&' {
+			buf.WriteRune(ch)
+			peek, _ := br.Peek(maxEntityLen - 1)
+			if _, n := parseEntity("&" + string(peek)); n > 0 {
+				buf.Write(peek[:n-1])
+				_, _ = br.Discard(n - 1)
+				col += n - 1
+			}
+			continue
+		}
+
 		switch ch {
 		case '#':
 			emitText()
@@ -182,6 +222,15 @@ func (l *Lexer) TokenizeCtx(ctx context.Context, r io.Reader) ([]Token, error) {
 		case '-':
 			emitText()
 			tokens = append(tokens, Token{Kind: TDash, Lexeme: "-", Pos: Pos{line, col}})
+		case '~':
+			emitText()
+			tokens = append(tokens, Token{Kind: TTilde, Lexeme: "~", Pos: Pos{line, col}})
+		case '<':
+			emitText()
+			tokens = append(tokens, Token{Kind: TLAngle, Lexeme: "<", Pos: Pos{line, col}})
+		case '>':
+			emitText()
+			tokens = append(tokens, Token{Kind: TRAngle, Lexeme: ">", Pos: Pos{line, col}})
 		case '\n':
 			emitText()
 			tokens = append(tokens, Token{Kind: TNewline, Lexeme: "\n", Pos: Pos{line, col}})
@@ -110,6 +110,17 @@ func TestTokenize_Table(t *testing.T) {
 			},
 			exactPos: false,
 		},
+		{
+			name: "'+' bullet with ≤3-space indent (indent split off as text)",
+			in:   "  + item\n",
+			want: []Token{
+				TK(TText, "  ", 1, 1),
+				TK(TText, "+ item", 1, 3),
+				TK(TNewline, "\n", 1, 0),
+				TK(TEOF, "", 2, 0),
+			},
+			exactPos: false,
+		},
 		{
 			name: "indent >3 means NOT an OL marker",
 			in:   "    4) not-ol\n",
@@ -141,6 +152,63 @@ func TestTokenize_Table(t *testing.T) {
 			},
 			exactPos: false,
 		},
+		{
+			name: "nested blockquote markers at BOL",
+			in:   "> > a\nb > c\n",
+			want: []Token{
+				TK(TQuoteMarker, ">", 1, 1),
+				TK(TText, " ", 1, 2),
+				TK(TQuoteMarker, ">", 1, 3),
+				TK(TText, " a", 1, 4),
+				TK(TNewline, "\n", 1, 6),
+				TK(TText, "b ", 2, 1),
+				TK(TRAngle, ">", 2, 3),
+				TK(TText, " c", 2, 4),
+				TK(TNewline, "\n", 2, 6),
+				TK(TEOF, "", 3, 0),
+			},
+			exactPos: true,
+		},
+		{
+			name: "backslash escapes stay text",
+			in:   "\\# \\*a\\\\*\\q\n",
+			want: []Token{
+				TK(TText, "\\# \\*a\\\\", 1, 1),
+				TK(TStar, "*", 1, 9),
+				TK(TText, "\\q", 1, 10),
+				TK(TNewline, "\n", 1, 12),
+				TK(TEOF, "", 2, 0),
+			},
+			exactPos: true,
+		},
+		{
+			name: "angle brackets mid-line",
+			in:   "see <a@b.io>\n",
+			want: []Token{
+				TK(TText, "see ", 1, 1),
+				TK(TLAngle, "<", 1, 5),
+				TK(TText, "a@b.io", 1, 6),
+				TK(TRAngle, ">", 1, 12),
+				TK(TNewline, "\n", 1, 13),
+				TK(TEOF, "", 2, 0),
+			},
+			exactPos: true,
+		},
+		{
+			name: "tildes, escaped tildes stay text",
+			in:   "~~a~~ \\~b\n",
+			want: []Token{
+				TK(TTilde, "~", 1, 1),
+				TK(TTilde, "~", 1, 2),
+				TK(TText, "a", 1, 3),
+				TK(TTilde, "~", 1, 4),
+				TK(TTilde, "~", 1, 5),
+				TK(TText, " \\~b", 1, 6),
+				TK(TNewline, "\n", 1, 10),
+				TK(TEOF, "", 2, 0),
+			},
+			exactPos: true,
+		},
 		{
 			name: "no OL at mid-line (BOL required)",
 			in:   "x 1) y\n",
@@ -222,6 +290,7 @@ func TestTokenize_RoundTripLexemes(t *testing.T) {
 		"   2. y",       // BOL with ≤3 indent
 		"    4) not-ol", // >3 indent so not a marker
 		"x 1) y",        // mid-line: no OL marker
+		"> > q\n>",      // nested quote markers
 	}
 	for _, in := range cases {
 		t.Run(fmt.Sprintf("roundtrip_%q", in), func(t *testing.T) {
@@ -8,19 +8,53 @@ import (
 // Document represents a complete markdown document.
 type Document struct {
 	Elements []*Element
+	// Definitions holds the link reference definitions of the document, keyed by their normalized label, and its
+	// footnote definitions, keyed by '^' and their normalized label.
+	Definitions map[string]*Element
 }
 
-// Element represents a single markdown element.
+// Element represents a single markdown element. Any markdown delimiters and escapes are added on render.
 type Element struct {
-	Kind      ElementKind
-	Text      string
+	Kind ElementKind
+	// Text holds the literal content of the element. The Text of an EKHTMLBlock or EKHTMLInline element is raw HTML,
+	// kept verbatim.
+	Text string
+	// LineBreak ends the line after the element. Between two lines of a paragraph that is a soft break, which
+	// HTML-style renderers write as a newline.
 	LineBreak bool
 	Level     int
+	Style     HeadingStyle
 	Href      string
+	Title     string
+	// Ref holds the label of a reference link or image, or of a footnote reference or definition.
+	Ref       string
+	LinkStyle LinkStyle
 	Alt       string
 	ListKind  ListType
-	Lang      string
-	Children  []*Element
+	// Bullet holds the '-', '*' or '+' marker of an unordered list.
+	Bullet byte
+	// Start holds the number of the first item of an ordered list, which may be 0. The Builder numbers from 1.
+	Start int
+	// Delim holds the '.' or ')' after the numbers of an ordered list, or the '*' or '_' delimiter of bold and
+	// italic text.
+	Delim byte
+	// Loose marks a list whose items are separated by blank lines.
+	Loose bool
+	// Task marks an EKListItem written with a "[ ]" box after its marker, Checked one written with an "[x]" box.
+	Task    bool
+	Checked bool
+	Lang    string
+	// Fence holds, as it was written, the fence of a code block, the marker of a rule, the backslash or spaces
+	// ending the line of an EKHardBreak, or the "~" or "~~" around struck text.
+	Fence    string
+	Indented bool
+	// Align holds the alignment of each column of an EKTable.
+	Align []Alignment
+	// Children holds the inline elements of a paragraph, span or table cell and the blocks of a list item, quote or
+	// EKFootnoteDef. A parsed list holds one EKListItem per item; a list built with flat inline Children still
+	// renders one item per line. A table holds its EKTableRow elements, header first, and a row its EKTableCell
+	// elements. A footnote reference made by the Builder holds the content of its footnote.
+	Children []*Element
 }
 
 //go:generate stringer -type=ElementKind
@@ -40,6 +74,40 @@ const (
 	EKImage
 	EKList
 	EKQuote
+	EKLinkDef
+	EKRaw
+	EKListItem
+	EKHardBreak
+	EKParagraph
+	EKHTMLBlock
+	EKHTMLInline
+	EKTable
+	EKTableRow
+	EKTableCell
+	EKStrike
+	EKFootnoteRef
+	EKFootnoteDef
+)
+
+// HeadingStyle represents the syntax a heading is written in.
+type HeadingStyle uint8
+
+const (
+	HeadingATX       HeadingStyle = iota // ## Title
+	HeadingATXClosed                     // ## Title ##
+	HeadingSetext                        // Title followed by a line of === or ---
+)
+
+// LinkStyle represents the syntax a link or image is written in.
+type LinkStyle uint8
+
+const (
+	LinkInline    LinkStyle = iota // [text](href)
+	LinkFull                       // [text][ref]
+	LinkCollapsed                  // [text][]
+	LinkShortcut                   // [text]
+	LinkAuto                       // <href>
+	LinkBare                       // href, an extended autolink without any markup
 )
 
 // ListType represents the type of list in markdown.
@@ -51,8 +119,36 @@ const (
 	ListOrdered
 )
 
+// Alignment represents the alignment of a table column, set by the colons of its delimiter row.
+type Alignment uint8
+
+const (
+	AlignNone   Alignment = iota // ---
+	AlignLeft                    // :--
+	AlignCenter                  // :-:
+	AlignRight                   // --:
+)
+
+// HTMLPolicy represents how a renderer writes raw HTML elements.
+type HTMLPolicy uint8
+
+const (
+	HTMLPass   HTMLPolicy = iota // the HTML is written as it is
+	HTMLEscape                   // the HTML is written as literal text
+	HTMLStrip                    // the HTML is left out
+)
+
 // Builder is a simple markdown builder that accumulates markdown elements
-type Builder struct{}
+type Builder struct {
+	// Bullet, when set to '-', '*' or '+', is written as the marker of every unordered list item
+	// instead of the Bullet recorded on the list. Lists without a Bullet use '-'.
+	Bullet byte
+	// Emphasis, when set to '*' or '_', is written as the delimiter of every bold and italic element
+	// instead of the Delim recorded on it. Elements without a Delim use "**" for bold and '_' for italic.
+	Emphasis byte
+	// HTML is the policy for raw HTML blocks and inline HTML, which are passed through by default.
+	HTML HTMLPolicy
+}
 
 func NewBuilder() *Builder {
 	return &Builder{}
@@ -93,6 +189,10 @@ const (
 	THash
 	TNewline
 	TOLMarker
+	TQuoteMarker
+	TLAngle
+	TRAngle
+	TTilde
 	TEOF
 )
 
@@ -113,7 +213,10 @@ func (t Token) String() string {
 
 // TokenParser
 
-type TokenParser struct{}
+type TokenParser struct {
+	// ExtendedAutolinks turns bare "www.", "http://" and "https://" URLs into links, as GFM does.
+	ExtendedAutolinks bool
+}
 
 func NewTokenParser() *TokenParser {
 	return &TokenParser{}
@@ -123,11 +226,15 @@ func NewTokenParser() *TokenParser {
 
 // Parser is a 'one-step' Markdown parser that converts Markdown text into a slice of Elements.
 type OnePassParser struct {
+	// ExtendedAutolinks turns bare "www.", "http://" and "https://" URLs into links, as GFM does.
+	ExtendedAutolinks bool
+
 	text        string
 	elements    []*Element
 	leafNode    *[]*Element
 	parentStack []*Element
 	lineCtx     variableLineCtx
+	defs        map[string]*Element
 	ctx         context.Context
 	err         error
 }
@@ -142,19 +249,23 @@ func NewOnePassParser() *OnePassParser {
 			basePointer:      0,
 			lookAheadPointer: 0,
 			specialChars:     []indexChar{},
-			ruleString:       "!*`[()]_",
+			ruleString:       "!*`[()]_<",
 			cache:            []byte{},
 		},
 	}
 }
 
-// variableLineCtx holds the context for parsing a single line of Markdown text.
+// variableLineCtx holds the context for parsing the inline content of a single line of Markdown text.
+// Nested spans, such as the inside of bold text, are parsed with a context of their own.
 type variableLineCtx struct {
+	text             string
 	basePointer      int
 	lookAheadPointer int
 	specialChars     []indexChar
 	ruleString       string
 	cache            []byte
+	elements         []*Element
+	delims           []*delimRun
 }
 
 // indexChar is a helper struct to hold the index and character of special characters in the line.
@@ -2,7 +2,9 @@ package gomd
 
 import (
 	"context"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // Parser is a Markdown parser that converts lexed tokens into a slice of Elements in a Document.
@@ -11,13 +13,41 @@ func (tp *TokenParser) ParseTokens(tks []Token) (*Document, error) {
 }
 
 // ParseTokensCtx parses lexed tokens into a Document, respecting the context for cancellation or timeout.
+// Link reference definitions are collected from all the lines first, so links can refer to definitions further down.
 func (tp *TokenParser) ParseTokensCtx(ctx context.Context, tks []Token) (*Document, error) {
+	var lines []string
+	for i := 0; i < len(tks) && tks[i].Kind != TEOF; {
+		var line string
+		line, i = lineAt(tks, i)
+		lines = append(lines, line)
+	}
+	defs := collectDefinitions(lines)
+
+	doc, err := tp.parseBlocksCtx(ctx, tks, defs)
+	doc.Definitions = defs
+	if err != nil {
+		return doc, err
+	}
+	linkFootnotes(defs, doc.Elements)
+	return doc, nil
+}
+
+// parseBlocksCtx parses the block structure of the tokens, resolving reference links against defs.
+func (tp *TokenParser) parseBlocksCtx(ctx context.Context, tks []Token, defs map[string]*Element) (*Document, error) {
 	var out []*Element
 
 	i := 0
-	bol := true // beginning of line
+	bol := true   // beginning of line
+	para := false // the previous line was paragraph text, which indented code can't interrupt
 	var currentList *Element
 	var currentListKind ListType
+	var lines *tokenLines // split on the first list item or table, to look ahead for their continuation lines
+	linesOf := func() *tokenLines {
+		if lines == nil {
+			lines = newTokenLines(tks)
+		}
+		return lines
+	}
 
 	checkCtx := func() error {
 		if err := ctx.Err(); err != nil {
@@ -34,10 +64,19 @@ func (tp *TokenParser) ParseTokensCtx(ctx context.Context, tks []Token) (*Docume
 			break
 		}
 
-		// preserve blank lines, newline at BOL means emit an empty line element.
+		// preserve blank lines, newline at BOL means emit a blank line element.
 		if tks[i].Kind == TNewline {
 			if bol {
-				out = append(out, &Element{Kind: EKText, Text: "", LineBreak: true})
+				// blank lines followed by another item of the list make it loose
+				if currentList != nil {
+					if next, ok := linesOf().nextItem(i, currentList); ok {
+						currentList.Loose = true
+						i = next
+						continue
+					}
+				}
+				out = append(out, &Element{Kind: EKNewLine, LineBreak: true})
+				para = false
 			}
 			bol = true
 			i++
@@ -46,77 +85,160 @@ func (tp *TokenParser) ParseTokensCtx(ctx context.Context, tks []Token) (*Docume
 
 		// block dispatch at BOL
 		if bol {
+			wasPara := para
+			para = false
+
+			// indented code block: 4+ columns of indent, outside of lists and not continuing a paragraph.
+			if !wasPara && currentList == nil && mayOpenIndentedCode(tks, i) {
+				el, next, err := parseIndentedBlockCtx(ctx, tks, i)
+				if err != nil {
+					return &Document{Elements: out}, err
+				}
+				if el != nil {
+					out = append(out, el)
+					i = next
+					bol = true
+					continue
+				}
+			}
+
+			// fenced code block: the lines up to the closing fence are kept verbatim.
+			if mayOpenFence(tks, i) {
+				if fence, ok := parseFenceOpen(collectUntilNewline(tks, i)); ok {
+					currentList = nil
+					el, next, err := parseFencedBlockCtx(ctx, tks, i, fence)
+					if err != nil {
+						return &Document{Elements: out}, err
+					}
+					out = append(out, el)
+					i = next
+					bol = true
+					continue
+				}
+			}
+
+			// HTML block: kept verbatim up to the line holding its end, or a blank line
+			if mayOpenHTML(tks, i) {
+				if cond := htmlBlockStart(collectUntilNewline(tks, i), wasPara); cond > 0 {
+					currentList = nil
+					el, next, err := parseHTMLBlockCtx(ctx, tks, i, cond)
+					if err != nil {
+						return &Document{Elements: out}, err
+					}
+					out = append(out, el)
+					i = next
+					bol = true
+					continue
+				}
+			}
+
+			// blockquote: '>' lines (and lazy continuation lines) are parsed recursively into the quote's children.
+			if isQuoteStart(tks, i) {
+				currentList = nil
+				el, next, err := tp.parseQuoteCtx(ctx, tks, i, defs)
+				if err != nil {
+					return &Document{Elements: out}, err
+				}
+				out = append(out, el)
+				i = next
+				bol = true
+				continue
+			}
+
 			// horizontal rule: line of only '-' and spaces, with >=3 dashes.
 			if ok, next := isHorizontalRuleLine(tks, i); ok {
 				// close any open list
 				currentList = nil
-				out = append(out, &Element{Kind: EKRule, Text: "\n---\n", LineBreak: true})
+				out = append(out, &Element{Kind: EKRule, Fence: strings.TrimSpace(collectUntilNewline(tks, i)), LineBreak: true})
 				i = next // consume entire line (including its trailing newline if present)
 				bol = true
 				continue
 			}
 
-			// heading: THash+ then rest of line as text
+			// heading: THash+ then rest of line as text, minus any closing hashes
 			if tks[i].Kind == THash {
-				level := 0
-				for i < len(tks) && tks[i].Kind == THash {
-					level++
-					i++
-					if err := checkCtx(); err != nil {
+				line := collectUntilNewline(tks, i)
+				if level, text, closed, ok := parseATXHeading(line); ok {
+					style := HeadingATX
+					if closed {
+						style = HeadingATXClosed
+					}
+					// the text is searched for after the opening hashes
+					indent := len(line) - len(strings.TrimLeft(line, " \t"))
+					el, err := tp.parseHeadingCtx(ctx, tks, i, indent+level, text, defs)
+					if err != nil {
 						return &Document{Elements: out}, err
 					}
+					el.Level = level
+					el.Style = style
+					out = append(out, el)
+
+					// advance to end-of-line, but don't consume the newline itself
+					for i < len(tks) && tks[i].Kind != TNewline && tks[i].Kind != TEOF {
+						i++
+					}
+					bol = false // <-- INFO: prevent next newline from being treated as a blank line
+					continue
 				}
-				if level > 6 {
-					level = 6
+			}
+
+			// footnote definition: its lines are parsed on their own, as those of a list item, while a link reference
+			// definition is already collected and kept in place so that it renders back where it was
+			if mayOpenLinkDef(tks, i) {
+				if label, text, ok := parseFootnoteDef(collectUntilNewline(tks, i)); ok {
+					el, next, err := tp.parseFootnoteDefCtx(ctx, tks, i, label, text, linesOf(), defs)
+					if err != nil {
+						return &Document{Elements: out}, err
+					}
+					currentList = nil
+					out = append(out, el)
+					i = next
+					bol = true
+					continue
 				}
-				text := collectUntilNewline(tks, i)
-				text = strings.TrimLeft(text, " ")
-				// advance to end-of-line, but don't consume the newline itself
-				for i < len(tks) && tks[i].Kind != TNewline && tks[i].Kind != TEOF {
-					i++
+				if def, ok := parseLinkDef(collectUntilNewline(tks, i)); ok {
+					currentList = nil
+					out = append(out, def)
+					_, i = lineAt(tks, i)
+					bol = true
+					continue
 				}
-				out = append(out, &Element{
-					Kind:      EKHeading,
-					Level:     level,
-					Text:      text,
-					LineBreak: true,
-				})
-				bol = false // <-- INFO: prevent next newline from being treated as a blank line
-				continue
 			}
 
-			// ordered list item: OL marker at BOL
-			if tks[i].Kind == TOLMarker {
+			// ordered list item: OL marker at BOL after up to 3 spaces, followed by a space or the end of the line as in
+			// parseListMarker, otherwise "3.14" is paragraph text
+			if m := markerAt(tks, i); tks[m].Kind == TOLMarker && isListMarkerAt(tks, m) {
 				if currentList == nil || currentListKind != ListOrdered {
-					currentList = &Element{Kind: EKList, ListKind: ListOrdered, Children: []*Element{}}
+					marker, _, _ := parseListMarker(tks[m].Lexeme)
+					currentList = marker.list()
 					out = append(out, currentList)
 					currentListKind = ListOrdered
 				}
-				i++                                                     // consume marker
-				elems, ni, err := parseInlineLineCtx(ctx, tks, i, true) // trim one leading space after marker
+				elems, loose, ni, err := tp.parseListItemCtx(ctx, tks, i, m+1, " ", linesOf(), defs) // trim one leading space after marker
 				if err != nil {
 					return &Document{Elements: out}, err
 				}
 				i = ni
 				currentList.Children = append(currentList.Children, elems...)
+				currentList.Loose = currentList.Loose || loose
 				bol = true
 				continue
 			}
 
-			// unordered list item: '-' + space at BOL
-			if tks[i].Kind == TDash && i+1 < len(tks) && tks[i+1].Kind == TText && strings.HasPrefix(tks[i+1].Lexeme, " ") {
-				if currentList == nil || currentListKind != ListUnordered {
-					currentList = &Element{Kind: EKList, ListKind: ListUnordered, Children: []*Element{}}
+			// unordered list item: '-', '*' or '+' and a space at BOL after up to 3 spaces, a different bullet starts a new list
+			if bullet, next, skip := bulletAt(tks, i); bullet != 0 {
+				if currentList == nil || currentListKind != ListUnordered || currentList.Bullet != bullet {
+					currentList = listMarker{kind: ListUnordered, bullet: bullet}.list()
 					out = append(out, currentList)
 					currentListKind = ListUnordered
 				}
-				i++                                                     // consume '-'
-				elems, ni, err := parseInlineLineCtx(ctx, tks, i, true) // drop a single leading space
+				elems, loose, ni, err := tp.parseListItemCtx(ctx, tks, i, next, skip, linesOf(), defs) // drop the rest of the marker
 				if err != nil {
 					return &Document{Elements: out}, err
 				}
 				i = ni
 				currentList.Children = append(currentList.Children, elems...)
+				currentList.Loose = currentList.Loose || loose
 				bol = true
 				continue
 			}
@@ -124,19 +246,48 @@ func (tp *TokenParser) ParseTokensCtx(ctx context.Context, tks []Token) (*Docume
 			// not a list marker: close any open list
 			currentList = nil
 
+			// table: a header row and a delimiter row, followed by the body rows
+			if mayOpenTable(tks, i) {
+				el, next, err := tp.parseTableCtx(ctx, tks, i, linesOf(), defs)
+				if err != nil {
+					return &Document{Elements: out}, err
+				}
+				if el != nil {
+					out = append(out, el)
+					i = next
+					bol = true
+					continue
+				}
+			}
+
+			// setext heading: a plain line underlined by a line of '=' or '-'
+			if level, next := setextUnderline(tks, i); level > 0 {
+				el, err := tp.parseHeadingCtx(ctx, tks, i, 0, strings.TrimSpace(collectUntilNewline(tks, i)), defs)
+				if err != nil {
+					return &Document{Elements: out}, err
+				}
+				el.Level = level
+				el.Style = HeadingSetext
+				out = append(out, el)
+				i = next
+				bol = true
+				continue
+			}
+
 			// plain line
-			elems, ni, err := parseInlineLineCtx(ctx, tks, i, false)
+			elems, ni, err := tp.parseInlineLineCtx(ctx, tks, i, "", defs)
 			if err != nil {
 				return &Document{Elements: out}, err
 			}
 			i = ni
 			out = append(out, elems...)
 			bol = true
+			para = true
 			continue
 		}
 
 		// not at BOL (rare): treat as plain line until newline
-		elems, ni, err := parseInlineLineCtx(ctx, tks, i, false)
+		elems, ni, err := tp.parseInlineLineCtx(ctx, tks, i, "", defs)
 		if err != nil {
 			return &Document{Elements: out}, err
 		}
@@ -145,9 +296,198 @@ func (tp *TokenParser) ParseTokensCtx(ctx context.Context, tks []Token) (*Docume
 		bol = true
 	}
 
+	groupListItems(out)
+	out = groupParagraphs(out)
 	return &Document{Elements: out}, nil
 }
 
+// parseListItemCtx parses the list item on the line at i, whose text starts at the token at, after skip.
+// An item that goes on past its first line, with indented or lazy continuation lines, that opens with a task box or
+// that holds something else than a line of inline text is parsed as in OnePassParser.processListItem: its lines are stripped of its indentation, lexed again and parsed on
+// their own to become the children of an EKListItem. It reports whether the item holds a blank line, which makes
+// the list loose.
+func (tp *TokenParser) parseListItemCtx(ctx context.Context, tks []Token, i, at int, skip string, lines *tokenLines, defs map[string]*Element) ([]*Element, bool, int, error) {
+	n := lines.at(i)
+	indent, rest := leadingIndent(lines.lines[n])
+	_, width, _ := parseListMarker(rest)
+	first := strings.TrimPrefix(rest[width:], " ")
+	contentCol := indent + len(rest) - len(first)
+
+	end := itemContinuation(lines.lines, n, first, contentCol)
+	checked, text, task := parseTaskBox(first)
+	// a line of inline text is left to the list, as in OnePassParser.processListItem
+	if end == n+1 && !task && isInlineItem(first) {
+		elems, next, err := tp.parseInlineLineCtx(ctx, tks, at, skip, defs)
+		return elems, false, next, err
+	}
+
+	inner := []string{text}
+	for _, line := range lines.lines[n+1 : end] {
+		inner = append(inner, stripIndent(line, contentCol))
+	}
+	itks, err := NewLexer().TokenizeCtx(ctx, strings.NewReader(strings.Join(inner, "\n")))
+	if err != nil {
+		return nil, false, i, err
+	}
+	doc, err := tp.parseBlocksCtx(ctx, itks, defs)
+	if err != nil {
+		return nil, false, i, err
+	}
+	item := &Element{Kind: EKListItem, Task: task, Checked: checked, Children: doc.Elements}
+	return []*Element{item}, hasBlankLine(inner[1:]), lines.starts[end], nil
+}
+
+// parseFootnoteDefCtx parses the footnote definition opened by the line at i, whose label and first text are given,
+// as in OnePassParser.processFootnoteDef. It returns the definition and the index after its last line.
+func (tp *TokenParser) parseFootnoteDefCtx(ctx context.Context, tks []Token, i int, label, text string, lines *tokenLines, defs map[string]*Element) (*Element, int, error) {
+	n := lines.at(i)
+	inner, end := footnoteLines(lines.lines, n, text)
+	itks, err := NewLexer().TokenizeCtx(ctx, strings.NewReader(strings.Join(inner, "\n")))
+	if err != nil {
+		return nil, i, err
+	}
+	doc, err := tp.parseBlocksCtx(ctx, itks, defs)
+	if err != nil {
+		return nil, i, err
+	}
+	return &Element{Kind: EKFootnoteDef, Ref: label, LineBreak: true, Children: doc.Elements}, lines.starts[end], nil
+}
+
+// mayOpenTable is a cheap check that the line at i holds a pipe before the lines are split for tableStart.
+func mayOpenTable(tks []Token, i int) bool {
+	for ; i < len(tks) && tks[i].Kind != TNewline && tks[i].Kind != TEOF; i++ {
+		if tks[i].Kind == TText && strings.Contains(tks[i].Lexeme, "|") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTableCtx consumes a table whose header row is the line at i, as in OnePassParser.processTable.
+// It returns a nil Element if the line doesn't open a table. Otherwise it returns the table and the index after its
+// last row, so a blank line ending it is left to the caller.
+func (tp *TokenParser) parseTableCtx(ctx context.Context, tks []Token, i int, lines *tokenLines, defs map[string]*Element) (*Element, int, error) {
+	n := lines.at(i)
+	if n+1 >= len(lines.lines) {
+		return nil, i, nil
+	}
+	align, ok := tableStart(lines.lines[n], lines.lines[n+1])
+	if !ok {
+		return nil, i, nil
+	}
+
+	table := &Element{Kind: EKTable, Align: align, LineBreak: true}
+	for row := n; row < len(lines.lines); row++ {
+		switch {
+		case row == n+1:
+			continue // the delimiter row
+		case row > n+1 && !continuesTable(lines.lines[row]):
+			return table, lines.starts[row], nil
+		}
+		cells, err := tp.parseCellsCtx(ctx, tks[lines.starts[row]:lines.starts[row+1]], lines.lines[row], defs)
+		if err != nil {
+			return nil, i, err
+		}
+		table.Children = append(table.Children, tableRow(cells, len(align)))
+	}
+	return table, lines.starts[len(lines.lines)], nil
+}
+
+// parseCellsCtx parses the inline content of each cell of a table row, from the tokens of the line holding text.
+// The escaped pipes of a cell are unescaped before its tokens are parsed.
+func (tp *TokenParser) parseCellsCtx(ctx context.Context, line []Token, text string, defs map[string]*Element) ([][]*Element, error) {
+	var cells [][]*Element
+	for _, c := range tableCells(text) {
+		ctks := sliceTokens(line, c[0], c[1])
+		for k := range ctks {
+			ctks[k].Lexeme = unescapePipes(ctks[k].Lexeme)
+		}
+		children, err := tp.parseInlineCtx(ctx, ctks, defs)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, children)
+	}
+	return cells, nil
+}
+
+// tokenLines holds the text of each line of the tokens and the index of its first token,
+// for the block rules that look ahead line by line.
+type tokenLines struct {
+	lines  []string
+	starts []int // one more than lines: the index after the last line
+}
+
+// newTokenLines splits the tokens into lines.
+func newTokenLines(tks []Token) *tokenLines {
+	tl := &tokenLines{}
+	i := 0
+	for i < len(tks) && tks[i].Kind != TEOF {
+		tl.starts = append(tl.starts, i)
+		var line string
+		line, i = lineAt(tks, i)
+		tl.lines = append(tl.lines, line)
+	}
+	tl.starts = append(tl.starts, i)
+	return tl
+}
+
+// at returns the number of the line starting at the token i.
+func (tl *tokenLines) at(i int) int { return sort.SearchInts(tl.starts, i) }
+
+// nextItem checks if the blank line starting at the token i is followed, after any other blank lines, by another item
+// of the list, returning the index of the first token of the item's line.
+func (tl *tokenLines) nextItem(i int, list *Element) (int, bool) {
+	n := tl.at(i)
+	for n < len(tl.lines) && onlySpaces(tl.lines[n]) {
+		n++
+	}
+	if n == len(tl.lines) || isRule(tl.lines[n]) {
+		return i, false
+	}
+	indent, rest := leadingIndent(tl.lines[n])
+	marker, _, ok := parseListMarker(rest)
+	if !ok || indent > 3 || marker.kind != list.ListKind || marker.bullet != list.Bullet {
+		return i, false
+	}
+	return tl.starts[n], true
+}
+
+// parseHeadingCtx builds a heading from its text, found in the line at i at or after the byte offset skip.
+// The tokens of the text are parsed for inline markup like any other line.
+func (tp *TokenParser) parseHeadingCtx(ctx context.Context, tks []Token, i, skip int, text string, defs map[string]*Element) (*Element, error) {
+	line := collectUntilNewline(tks, i)
+	from := skip + strings.Index(line[skip:], text)
+	children, err := tp.parseInlineCtx(ctx, sliceTokens(tks[i:], from, from+len(text)), defs)
+	if err != nil {
+		return nil, err
+	}
+	el := spanElement(EKHeading, children)
+	el.LineBreak = true
+	return el, nil
+}
+
+// sliceTokens returns the tokens covering the bytes [from, to) of their joined lexemes,
+// cutting the tokens at the edges down to the bytes inside.
+func sliceTokens(tks []Token, from, to int) []Token {
+	var out []Token
+	pos := 0
+	for _, t := range tks {
+		if pos >= to {
+			break
+		}
+		end := pos + len(t.Lexeme)
+		if end > from {
+			lo, hi := max(from, pos)-pos, min(to, end)-pos
+			t.Lexeme = t.Lexeme[lo:hi]
+			t.Pos.Col += lo
+			out = append(out, t)
+		}
+		pos = end
+	}
+	return out
+}
+
 // collectUntilNewline collects tokens until a newline or EOF is encountered.
 func collectUntilNewline(tks []Token, i int) string {
 	var b strings.Builder
@@ -158,6 +498,228 @@ func collectUntilNewline(tks []Token, i int) string {
 	return b.String()
 }
 
+// lineAt returns the source text of the line starting at i, and the index after its trailing newline (or of the EOF).
+func lineAt(tks []Token, i int) (string, int) {
+	line := collectUntilNewline(tks, i)
+	for i < len(tks) && tks[i].Kind != TNewline && tks[i].Kind != TEOF {
+		i++
+	}
+	if i < len(tks) && tks[i].Kind == TNewline {
+		i++
+	}
+	return line, i
+}
+
+// mayOpenFence is a cheap check on the first tokens of a line before its text is collected for parseFenceOpen.
+func mayOpenFence(tks []Token, i int) bool {
+	if tks[i].Kind == TText && onlySpaces(tks[i].Lexeme) {
+		i++
+	}
+	return i < len(tks) && (tks[i].Kind == TBacktick || tks[i].Kind == TTilde)
+}
+
+// parseFencedBlockCtx consumes a fenced code block opened by the line at i.
+// It returns the code block Element and the index of the line after the closing fence.
+func parseFencedBlockCtx(ctx context.Context, tks []Token, i int, fence codeFence) (*Element, int, error) {
+	_, i = lineAt(tks, i) // opening fence
+	code := []string{}
+	for i < len(tks) && tks[i].Kind != TEOF {
+		if err := ctx.Err(); err != nil {
+			return nil, i, err
+		}
+		line, next := lineAt(tks, i)
+		i = next
+		if fence.closes(line) {
+			break
+		}
+		code = append(code, fence.content(line))
+	}
+	return &Element{
+		Kind:      EKCodeBlock,
+		Lang:      fence.lang,
+		Fence:     fence.fence(),
+		Text:      strings.Join(code, "\n"),
+		LineBreak: true,
+	}, i, nil
+}
+
+// mayOpenHTML is a cheap check that the line at i starts with '<' after optional indentation, before its text is
+// collected for htmlBlockStart.
+func mayOpenHTML(tks []Token, i int) bool {
+	if tks[i].Kind == TText && onlySpaces(tks[i].Lexeme) {
+		i++
+	}
+	return i < len(tks) && tks[i].Kind == TLAngle
+}
+
+// parseHTMLBlockCtx consumes an HTML block of the start condition opened by the line at i, its lines are kept verbatim.
+// It returns the HTML block Element and the index of the line after it, so a blank line ending it is left to the caller.
+func parseHTMLBlockCtx(ctx context.Context, tks []Token, i int, cond int) (*Element, int, error) {
+	line, next := lineAt(tks, i)
+	block := []string{line}
+	for !htmlBlockEnds(cond, line) && next < len(tks) && tks[next].Kind != TEOF {
+		if err := ctx.Err(); err != nil {
+			return nil, next, err
+		}
+		var after int
+		line, after = lineAt(tks, next)
+		if cond >= 6 && onlySpaces(line) {
+			break
+		}
+		block = append(block, line)
+		next = after
+	}
+	return &Element{Kind: EKHTMLBlock, Text: strings.Join(block, "\n"), LineBreak: true}, next, nil
+}
+
+// mayOpenIndentedCode is a cheap check that the line at i starts with whitespace before its text is collected.
+func mayOpenIndentedCode(tks []Token, i int) bool {
+	return tks[i].Kind == TText && (strings.HasPrefix(tks[i].Lexeme, " ") || strings.HasPrefix(tks[i].Lexeme, "\t"))
+}
+
+// parseIndentedBlockCtx consumes an indented code block starting at the line at i.
+// It returns a nil Element if the line is not indented enough, or blank. Otherwise it returns the code block
+// and the index after its last code line, so trailing blank lines are left to the caller.
+func parseIndentedBlockCtx(ctx context.Context, tks []Token, i int) (*Element, int, error) {
+	code := []string{}
+	pending := []string{}
+	end := i
+	for i < len(tks) && tks[i].Kind != TEOF {
+		if err := ctx.Err(); err != nil {
+			return nil, i, err
+		}
+		line, next := lineAt(tks, i)
+		rest, ok := stripCodeIndent(line)
+		if onlySpaces(line) {
+			if len(code) == 0 {
+				return nil, i, nil
+			}
+			pending = append(pending, rest)
+			i = next
+			continue
+		}
+		if !ok {
+			break
+		}
+		code = append(code, pending...)
+		code = append(code, rest)
+		pending = pending[:0]
+		i = next
+		end = i
+	}
+	if len(code) == 0 {
+		return nil, end, nil
+	}
+	return &Element{Kind: EKCodeBlock, Indented: true, Text: strings.Join(code, "\n"), LineBreak: true}, end, nil
+}
+
+// isQuoteStart checks if the line at i opens with a blockquote marker, after optional indentation.
+func isQuoteStart(tks []Token, i int) bool {
+	if tks[i].Kind == TText && onlySpaces(tks[i].Lexeme) {
+		i++
+	}
+	return i < len(tks) && tks[i].Kind == TQuoteMarker
+}
+
+// stripQuoteTokens drops n levels of blockquote markers from the tokens of a quoted line: for each, the indentation,
+// the '>' marker and one following space.
+func stripQuoteTokens(line []Token, n int) []Token {
+	line = append([]Token(nil), line...) // the first token is cut in place below
+	for ; n > 0; n-- {
+		if len(line) > 0 && line[0].Kind == TText && onlySpaces(line[0].Lexeme) {
+			line = line[1:]
+		}
+		if len(line) == 0 || line[0].Kind != TQuoteMarker {
+			return line
+		}
+		line = line[1:]
+		if len(line) > 0 && line[0].Kind == TText && (strings.HasPrefix(line[0].Lexeme, " ") || strings.HasPrefix(line[0].Lexeme, "\t")) {
+			line[0].Lexeme = line[0].Lexeme[1:]
+			line[0].Pos.Col++
+			if line[0].Lexeme == "" {
+				line = line[1:]
+			}
+		}
+	}
+	return line
+}
+
+// parseQuoteCtx consumes a blockquote opened by the line at i.
+// The quoted lines are stripped of their markers and parsed as a document of their own,
+// which is how nested quotes, lists and headings inside quotes are handled.
+// Quotes that hold nothing but another quote are stripped along with it, as in OnePassParser.
+func (tp *TokenParser) parseQuoteCtx(ctx context.Context, tks []Token, i int, defs map[string]*Element) (*Element, int, error) {
+	var inner []string
+	var quoted []bool
+	var starts []int // the index of the first token of each line, then the index after the last line
+	prev := ""
+	for i < len(tks) && tks[i].Kind != TEOF {
+		if err := ctx.Err(); err != nil {
+			return nil, i, err
+		}
+		line, next := lineAt(tks, i)
+		rest, ok := stripQuoteMarker(line)
+		if !ok && !lazyContinues(prev, line) {
+			break
+		}
+		inner = append(inner, rest)
+		quoted = append(quoted, ok)
+		starts = append(starts, i)
+		prev = rest
+		i = next
+	}
+	starts = append(starts, i)
+	depth, stripped := nestedQuotes(inner, quoted)
+
+	var toks []Token
+	for k := range inner {
+		toks = append(toks, stripQuoteTokens(tks[starts[k]:starts[k+1]], btoi(quoted[k])+stripped[k])...)
+	}
+	eof := Token{Kind: TEOF}
+	if i < len(tks) {
+		eof.Pos = tks[i].Pos
+	}
+	toks = append(toks, eof)
+
+	doc, err := tp.parseBlocksCtx(ctx, toks, defs)
+	if err != nil {
+		return nil, i, err
+	}
+	return nestQuote(doc.Elements, depth), i, nil
+}
+
+// setextUnderline checks if the line following the one at i is a setext underline ("===" or "---").
+// It returns the heading level (or 0) and the index after the underline.
+func setextUnderline(tks []Token, i int) (int, int) {
+	j := i
+	for j < len(tks) && tks[j].Kind != TNewline && tks[j].Kind != TEOF {
+		j++
+	}
+	if j >= len(tks) || tks[j].Kind != TNewline || j == i {
+		return 0, i
+	}
+	j++ // the underline starts after the newline
+
+	level := 0
+	trailing := false // only spaces may follow the underline, "- - -" is a rule
+	for ; j < len(tks) && tks[j].Kind != TNewline && tks[j].Kind != TEOF; j++ {
+		switch {
+		case tks[j].Kind == TDash && level != 1 && !trailing:
+			level = 2
+		case tks[j].Kind == TText && onlySpaces(tks[j].Lexeme) && (level > 0 || len(tks[j].Lexeme) <= 3):
+			trailing = level > 0
+		case tks[j].Kind == TText && level == 0 && setextLevel(tks[j].Lexeme) == 1:
+			level = 1
+		default:
+			return 0, i
+		}
+	}
+	if j < len(tks) && tks[j].Kind == TNewline {
+		j++
+	}
+	return level, j
+}
+
 // onlySpaces checks if the string contains only spaces or tabs.
 func onlySpaces(s string) bool {
 	for _, r := range s {
@@ -168,197 +730,382 @@ func onlySpaces(s string) bool {
 	return true
 }
 
-// checks if the current line is a horizontal rule (>=3 '-' and only spaces otherwise).
+// checks if the current line is a horizontal rule (>=3 of the same '-', '*' or '_' and only spaces otherwise).
 // returns (ok, nextIndexAfterThisLine)
 func isHorizontalRuleLine(tks []Token, i int) (bool, int) {
+	// cheap check on the first tokens before the line is collected
 	j := i
-	dashes := 0
-	valid := true
-	for j < len(tks) && tks[j].Kind != TNewline && tks[j].Kind != TEOF {
-		switch tks[j].Kind {
-		case TDash:
-			dashes++
-		case TText:
-			if !onlySpaces(tks[j].Lexeme) {
-				valid = false
-			}
-		default:
-			valid = false
-		}
-		if !valid {
-			break
-		}
+	if j < len(tks) && tks[j].Kind == TText && onlySpaces(tks[j].Lexeme) {
 		j++
 	}
-	if !valid || dashes < 3 {
+	if j >= len(tks) || (tks[j].Kind != TDash && tks[j].Kind != TStar && tks[j].Kind != TUnderscore) {
 		return false, i
 	}
-	// consume the trailing newline if present
-	if j < len(tks) && tks[j].Kind == TNewline {
-		j++
+	line, next := lineAt(tks, i)
+	if !isRule(line) {
+		return false, i
+	}
+	return true, next
+}
+
+// isListMarkerAt checks if the TOLMarker at i is followed by a space or the end of the line, so that its line opens
+// a list item as it does in OnePassParser.
+func isListMarkerAt(tks []Token, i int) bool {
+	_, _, ok := parseListMarker(collectUntilNewline(tks, i))
+	return ok
+}
+
+// bulletAt checks if the line at i opens with a bullet after up to 3 spaces: a '-' or '*' token or a "+" text
+// followed by a space. It returns the bullet, the index after its token and what remains of the marker to skip,
+// or a zero bullet.
+func bulletAt(tks []Token, i int) (byte, int, string) {
+	m := markerAt(tks, i)
+	switch {
+	case (tks[m].Kind == TDash || tks[m].Kind == TStar) && m+1 < len(tks) && tks[m+1].Kind == TText && strings.HasPrefix(tks[m+1].Lexeme, " "):
+		return tks[m].Lexeme[0], m + 1, " "
+	case tks[m].Kind == TText && strings.HasPrefix(tks[m].Lexeme, "+ "):
+		return '+', m, "+ "
 	}
-	return true, j
+	return 0, i, ""
+}
+
+// markerAt returns the index of the token after the indentation of up to 3 spaces the line at i starts with, where
+// its list marker would be. The lexer keeps the indentation as a text token of its own.
+func markerAt(tks []Token, i int) int {
+	if tks[i].Kind == TText && len(tks[i].Lexeme) <= 3 && onlySpaces(tks[i].Lexeme) && i+1 < len(tks) {
+		return i + 1
+	}
+	return i
 }
 
 // parse a single logical line into inline Elements.
 // If trimLeadingSpace is true, drop exactly one leading space in the first TText.
-func parseInlineLineCtx(ctx context.Context, tks []Token, i int, trimLeadingSpace bool) ([]*Element, int, error) {
+// Reference links are resolved against defs.
+func (tp *TokenParser) parseInlineLineCtx(ctx context.Context, tks []Token, i int, skip string, defs map[string]*Element) ([]*Element, int, error) {
+	end := i
+	for end < len(tks) && tks[end].Kind != TNewline && tks[end].Kind != TEOF {
+		end++
+	}
+	next := end + btoi(end < len(tks) && tks[end].Kind == TNewline)
+
+	line := tks[i:end]
+	if skip != "" && len(line) > 0 && line[0].Kind == TText && strings.HasPrefix(line[0].Lexeme, skip) {
+		first := line[0]
+		first.Lexeme = first.Lexeme[len(skip):] // drop one leading space after "-" or "1)"/"2.", or "+ "
+		line = append([]Token{first}, line[1:]...)
+	}
+
+	// two spaces or a backslash at the end of the line make a hard break, if the paragraph goes on after it
+	cont := next < len(tks) && tks[next].Kind != TEOF && isParagraphLine(collectUntilNewline(tks, next))
+	src := joinLexemes(line)
+	text, brk := splitHardBreak(src, cont)
+	if len(text) < len(src) {
+		line = sliceTokens(line, 0, len(text))
+	}
+
+	out, err := tp.parseInlineCtx(ctx, line, defs)
+	if err != nil {
+		return out, i, err
+	}
+
+	// empty line
+	if len(out) == 0 {
+		return []*Element{{Kind: EKText, Text: "", LineBreak: true}}, next, nil
+	}
+
+	// mark only the last as a line break
+	if brk != "" {
+		return append(out, &Element{Kind: EKHardBreak, Fence: brk, LineBreak: true}), next, nil
+	}
+	out[len(out)-1].LineBreak = true
+	return out, next, nil
+}
+
+// parseInlineCtx parses the tokens of a span of inline content.
+// The tokens inside bold, italic and link text are parsed recursively, so they can nest.
+func (tp *TokenParser) parseInlineCtx(ctx context.Context, tks []Token, defs map[string]*Element) ([]*Element, error) {
 	var out []*Element
+	var runs []*delimRun
 	var buf strings.Builder
-	flushText := func(linebreak bool) {
+	flushText := func() {
 		if buf.Len() == 0 {
 			return
 		}
-		out = append(out, &Element{Kind: EKText, Text: buf.String(), LineBreak: linebreak})
+		out = append(out, &Element{Kind: EKText, Text: buf.String()})
 		buf.Reset()
 	}
 
-	first := true
-	lastWasLink := false
+	// the source text of the tokens is joined once, the text from the token at j on is src[start(j):]
+	src := joinLexemes(tks)
+	ends := make([]int, len(tks)) // the offset in src at which each token ends
+	for j, n := 0, 0; j < len(tks); j++ {
+		n += len(tks[j].Lexeme)
+		ends[j] = n
+	}
 
-	checkCtx := func() error {
-		if err := ctx.Err(); err != nil {
-			return err
+	i := 0
+	rangle := 0 // the index of the next TRAngle, or len(tks) if there is none
+	// a split token loses the start of its lexeme, so its text still ends at the same offset
+	start := func(j int) int { return ends[j] - len(tks[j].Lexeme) }
+	owned := false // tks is copied before its first split, so the caller's tokens stay untouched
+	splitToken := func(at int) {
+		if !owned {
+			tks = append([]Token(nil), tks...)
+			owned = true
+		}
+		tks[i].Lexeme = tks[i].Lexeme[at:]
+	}
+	// advance past the tokens spanning the next n bytes of source text, a token they end inside of is split
+	skipBytes := func(n int) {
+		for i < len(tks) && n >= len(tks[i].Lexeme) {
+			n -= len(tks[i].Lexeme)
+			i++
+		}
+		if n > 0 {
+			splitToken(n)
 		}
-		return nil
 	}
 
-loop:
 	for i < len(tks) {
-		if err := checkCtx(); err != nil {
-			return out, i, err
+		if err := ctx.Err(); err != nil {
+			return out, err
 		}
 
 		t := tks[i]
 		switch t.Kind {
-		case TNewline, TEOF:
-			flushText(false)
-			break loop
-
 		case TBacktick:
-			// `code`
-			if i+2 < len(tks) && tks[i+1].Kind == TText && tks[i+2].Kind == TBacktick {
-				flushText(false)
-				code := tks[i+1].Lexeme
-				out = append(out, &Element{Kind: EKCodeSpan, Text: inlineWrap("`", escapeBackticks(code))})
-				i += 3
-				first = false
-				lastWasLink = false
+			// `code`, its content is never parsed any further. The span is read from the source text, as backslashes
+			// inside it are literal and don't escape the backticks that close it.
+			code, n := parseCodeSpan(src[start(i):])
+			if n == 0 {
+				// a run that isn't closed is literal text
+				for i < len(tks) && tks[i].Kind == TBacktick {
+					buf.WriteString(tks[i].Lexeme)
+					i++
+				}
 				continue
 			}
-			buf.WriteString(t.Lexeme)
-			i++
-			first = false
-			lastWasLink = false
-
-		case TStar:
-			// **bold** only; single '*' treated as literal
-			if i+4 < len(tks) && tks[i+1].Kind == TStar && tks[i+2].Kind == TText && tks[i+3].Kind == TStar && tks[i+4].Kind == TStar {
-				flushText(false)
-				inner := tks[i+2].Lexeme
-				out = append(out, &Element{Kind: EKBold, Text: inlineWrap("**", escapeInline(inner))})
-				i += 5
-				first = false
-				lastWasLink = false
-				continue
+			flushText()
+			out = append(out, &Element{Kind: EKCodeSpan, Text: code})
+			skipBytes(n)
+			continue
+
+		case TStar, TUnderscore, TTilde:
+			// a run of '*', '_' or '~', matched into bold, italic and struck text once the span is parsed
+			j := i
+			for j < len(tks) && tks[j].Kind == t.Kind {
+				j++
 			}
-			// literal '*'
-			buf.WriteString(t.Lexeme)
-			i++
-			first = false
-			lastWasLink = false
-
-		case TUnderscore:
-			// _italic_
-			if i+2 < len(tks) && tks[i+1].Kind == TText && tks[i+2].Kind == TUnderscore {
-				flushText(false)
-				inner := tks[i+1].Lexeme
-				out = append(out, &Element{Kind: EKItalic, Text: inlineWrap("_", escapeInline(inner))})
-				i += 3
-				first = false
-				lastWasLink = false
-				continue
+			before, after := ' ', ' '
+			if i > 0 {
+				before, _ = utf8.DecodeLastRuneInString(tks[i-1].Lexeme)
 			}
-			buf.WriteString(t.Lexeme)
-			i++
-			first = false
-			lastWasLink = false
+			if j < len(tks) && tks[j].Lexeme != "" {
+				after, _ = utf8.DecodeRuneInString(tks[j].Lexeme)
+			}
+			run := newDelimRun(t.Lexeme[0], j-i, before, after)
+			flushText()
+			out = append(out, run.el)
+			runs = append(runs, run)
+			i = j
+			continue
 
 		case TBang:
-			// ![alt](src)
-			if i+6 < len(tks) &&
-				tks[i+1].Kind == TLBracket &&
-				tks[i+2].Kind == TText &&
-				tks[i+3].Kind == TRBracket &&
-				tks[i+4].Kind == TLParen &&
-				tks[i+5].Kind == TText &&
-				tks[i+6].Kind == TRParen {
-				flushText(false)
-				alt := tks[i+2].Lexeme
-				src := tks[i+5].Lexeme
-				out = append(out, &Element{Kind: EKImage, Alt: alt, Href: escapeURL(src)})
-				i += 7
-				first = false
-				lastWasLink = false
-				continue
+			// ![alt](src) or ![alt][ref]
+			if i+1 < len(tks) && tks[i+1].Kind == TLBracket {
+				if closing, ok := matchBracketTokens(tks, i+1); ok {
+					alt := joinLexemes(tks[i+2 : closing])
+					if src, next, ok := matchDestTokens(tks, closing); ok {
+						flushText()
+						out = append(out, &Element{Kind: EKImage, Alt: unescapeText(alt), Href: unescapeText(src)})
+						i = next
+						continue
+					}
+					if label, style, def, next := lookupRefTokens(tks, closing, alt, src[ends[closing]:], defs); def != nil {
+						flushText()
+						out = append(out, &Element{Kind: EKImage, Alt: unescapeText(alt), Href: def.Href, Title: def.Title, Ref: label, LinkStyle: style})
+						i = next
+						continue
+					}
+				}
 			}
-			buf.WriteString(t.Lexeme)
-			i++
-			first = false
-			lastWasLink = false
 
 		case TLBracket:
-			// [text](href)
-			if i+5 < len(tks) &&
-				tks[i+1].Kind == TText &&
-				tks[i+2].Kind == TRBracket &&
-				tks[i+3].Kind == TLParen &&
-				tks[i+4].Kind == TText &&
-				tks[i+5].Kind == TRParen {
-				flushText(false)
-				text := escapeLinkText(tks[i+1].Lexeme)
-				href := escapeURL(tks[i+4].Lexeme)
-				out = append(out, &Element{Kind: EKLink, Text: text, Href: href})
-				i += 6
-				first = false
-				lastWasLink = true
+			// [text](href) or [text][ref]
+			closing, ok := matchBracketTokens(tks, i)
+			if !ok {
+				break
+			}
+			// [^label], to a footnote defined in the document
+			ref := joinLexemes(tks[i : closing+1])
+			if label, n := parseFootnoteRef(ref); n == len(ref) && defs[footnoteKey(label)] != nil {
+				flushText()
+				out = append(out, &Element{Kind: EKFootnoteRef, Ref: label})
+				i = closing + 1
 				continue
 			}
-			buf.WriteString(t.Lexeme)
-			i++
-			first = false
-			lastWasLink = false
+			display := tks[i+1 : closing]
+			var el *Element
+			next := 0
+			if href, n, ok := matchDestTokens(tks, closing); ok {
+				el = &Element{Kind: EKLink, Href: unescapeText(href)}
+				next = n
+			} else if label, style, def, n := lookupRefTokens(tks, closing, src[ends[i]:start(closing)], src[ends[closing]:], defs); def != nil {
+				el = &Element{Kind: EKLink, Href: def.Href, Title: def.Title, Ref: label, LinkStyle: style}
+				next = n
+			} else {
+				break
+			}
 
-		default:
-			// TText or punctuation
-			lit := t.Lexeme
-			if first && trimLeadingSpace && t.Kind == TText && strings.HasPrefix(lit, " ") {
-				lit = lit[1:] // drop one leading space after "-" or "1)"/"2."
+			children, err := tp.parseInlineCtx(ctx, display, defs)
+			if err != nil {
+				return out, err
+			}
+			flushText()
+			if isPlainText(children) {
+				el.Text = plainText(children)
+			} else {
+				el.Children = children
 			}
-			// avoid double spacing: builder already appends one space after links (when !LineBreak).
-			if lastWasLink && t.Kind == TText && lit == " " {
-				i++
-				first = false
-				lastWasLink = false
+			out = append(out, el)
+			i = next
+			continue
+
+		case TLAngle:
+			// <https://example.com> or <user@example.com>, up to the next '>', which is only looked for again past it
+			if rangle <= i {
+				if rangle = findToken(tks, i+1, TRAngle); rangle < 0 {
+					rangle = len(tks)
+				}
+			}
+			if rangle < len(tks) {
+				if el, _ := parseAutolink(src[start(i):ends[rangle]]); el != nil {
+					flushText()
+					out = append(out, el)
+					i = rangle + 1
+					continue
+				}
+			}
+
+			// inline HTML, a tag, comment, processing instruction, declaration or CDATA section
+			rest := src[start(i):]
+			if n := parseHTMLTag(rest); n > 0 {
+				flushText()
+				out = append(out, &Element{Kind: EKHTMLInline, Text: rest[:n]})
+				skipBytes(n)
 				continue
 			}
-			buf.WriteString(lit)
-			i++
-			first = false
-			lastWasLink = false
+
+		case TText:
+			// bare URLs, which may run on into the following tokens
+			if !tp.ExtendedAutolinks {
+				break
+			}
+			from := start(i)
+			off, n := findBareURL(src, from, ends[i])
+			if n == 0 {
+				break
+			}
+			buf.WriteString(t.Lexeme[:off])
+			flushText()
+			out = append(out, bareLink(src[from+off:from+off+n]))
+
+			skipBytes(off + n)
+			continue
+		}
+
+		// TText, punctuation, or a delimiter without a match
+		if t.Kind == TText {
+			buf.WriteString(unescapeText(t.Lexeme))
+		} else {
+			buf.WriteString(t.Lexeme)
 		}
+		i++
 	}
 
-	// empty line
-	if len(out) == 0 && buf.Len() == 0 {
-		out = append(out, &Element{Kind: EKText, Text: "", LineBreak: true})
-		return out, i + btoi(i < len(tks) && tks[i].Kind == TNewline), nil
+	flushText()
+	return resolveEmphasis(out, runs), nil
+}
+
+// joinLexemes concatenates the lexemes of the tokens, giving back their source text.
+func joinLexemes(tks []Token) string {
+	var b strings.Builder
+	for _, t := range tks {
+		b.WriteString(t.Lexeme)
 	}
+	return b.String()
+}
 
-	if buf.Len() > 0 {
-		out = append(out, &Element{Kind: EKText, Text: buf.String()})
+// findToken returns the index of the first token of the kind at or after from, or -1.
+func findToken(tks []Token, from int, kind TokenKind) int {
+	for j := from; j < len(tks); j++ {
+		if tks[j].Kind == kind {
+			return j
+		}
 	}
-	// mark only the last as a line break
-	out[len(out)-1].LineBreak = true
-	return out, i + btoi(i < len(tks) && tks[i].Kind == TNewline), nil
+	return -1
+}
+
+// matchBracketTokens finds the TRBracket closing the TLBracket at open, skipping over nested bracket pairs.
+func matchBracketTokens(tks []Token, open int) (int, bool) {
+	depth := 0
+	for j := open + 1; j < len(tks); j++ {
+		switch tks[j].Kind {
+		case TLBracket:
+			depth++
+		case TRBracket:
+			if depth == 0 {
+				return j, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
+
+// matchDestTokens matches the "(...)" destination right after the TRBracket at closing.
+// It returns the text between the parentheses and the index after the TRParen.
+func matchDestTokens(tks []Token, closing int) (string, int, bool) {
+	if closing+1 >= len(tks) || tks[closing+1].Kind != TLParen {
+		return "", 0, false
+	}
+	paren := findToken(tks, closing+2, TRParen)
+	if paren < 0 {
+		return "", 0, false
+	}
+	return joinLexemes(tks[closing+2 : paren]), paren + 1, true
+}
+
+// lookupRefTokens checks if the text of a link or image, closed by the TRBracket at closing and followed by the
+// source text rest, forms a reference to a defined label. It returns the label as written, the reference style,
+// the definition (nil if the label is not defined) and the index after the reference.
+func lookupRefTokens(tks []Token, closing int, text, rest string, defs map[string]*Element) (string, LinkStyle, *Element, int) {
+	label, style, n := refSuffix(text, rest)
+
+	// n counts bytes, advance over the tokens it spans
+	next := closing + 1
+	for taken := 0; taken < n; next++ {
+		taken += len(tks[next].Lexeme)
+	}
+	return label, style, linkDef(defs, label), next
+}
+
+// findBareURL looks for a bare URL starting inside a TText, whose lexeme spans src[from:to] of the source text of
+// its span. It returns the offset of the URL in the lexeme and the length of the URL, which may run on into the
+// following tokens, or a length of 0.
+func findBareURL(src string, from, to int) (int, int) {
+	for j := from; j < to; j++ {
+		if n := bareURLAt(src, j); n > 0 {
+			return j - from, n
+		}
+	}
+	return 0, 0
+}
+
+// mayOpenLinkDef is a cheap check that the line at i starts with '[' before its text is collected for parseLinkDef.
+func mayOpenLinkDef(tks []Token, i int) bool {
+	if tks[i].Kind == TText && onlySpaces(tks[i].Lexeme) {
+		i++
+	}
+	return i < len(tks) && tks[i].Kind == TLBracket
 }
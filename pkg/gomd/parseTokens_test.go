@@ -34,6 +34,26 @@ func assertElems(t *testing.T, got, want []*Element) {
 	}
 }
 
+// para wraps the inline elements of a paragraph.
+func para(inline ...*Element) *Element {
+	return &Element{Kind: EKParagraph, Children: inline}
+}
+
+// text is inline text, and line is text that ends its line.
+func text(s string) *Element { return &Element{Kind: EKText, Text: s} }
+func line(s string) *Element { return &Element{Kind: EKText, Text: s, LineBreak: true} }
+
+// quote wraps the blocks of a quote.
+func quote(blocks ...*Element) *Element { return &Element{Kind: EKQuote, Children: blocks} }
+
+// bullets is a '-' list of the items, item wraps the blocks of a list item and paraItem holds a paragraph of the
+// inline elements.
+func bullets(items ...*Element) *Element {
+	return &Element{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: items}
+}
+func item(blocks ...*Element) *Element     { return &Element{Kind: EKListItem, Children: blocks} }
+func paraItem(inline ...*Element) *Element { return item(para(inline...)) }
+
 func TestParseTokens_Heading(t *testing.T) {
 	got := mustParse(t, "# Hello\n")
 	want := []*Element{
@@ -45,7 +65,7 @@ func TestParseTokens_Heading(t *testing.T) {
 func TestParseTokens_Paragraph_Simple(t *testing.T) {
 	got := mustParse(t, "hello world\n")
 	want := []*Element{
-		{Kind: EKText, Text: "hello world", LineBreak: true},
+		para(&Element{Kind: EKText, Text: "hello world", LineBreak: true}),
 	}
 	assertElems(t, got, want)
 }
@@ -55,16 +75,16 @@ func TestParseTokens_Inline_Bold_Italic_Code_Link_Image(t *testing.T) {
 	got := mustParse(t, src)
 	// Bold uses "**...**" per Builder’s conventions.
 	// We do NOT emit a separate " " after a link; the builder appends one automatically.
-	want := []*Element{
-		{Kind: EKBold, Text: "**b**"},
-		{Kind: EKText, Text: " "},
-		{Kind: EKItalic, Text: "_i_"},
-		{Kind: EKText, Text: " "},
-		{Kind: EKCodeSpan, Text: "`c`"},
-		{Kind: EKText, Text: " "},
-		{Kind: EKLink, Text: "x", Href: "y"},
-		{Kind: EKImage, Alt: "alt", Href: "img", LineBreak: true}, // last inline in line gets LineBreak
-	}
+	want := []*Element{para(
+		&Element{Kind: EKBold, Delim: '*', Text: "b"},
+		&Element{Kind: EKText, Text: " "},
+		&Element{Kind: EKItalic, Delim: '_', Text: "i"},
+		&Element{Kind: EKText, Text: " "},
+		&Element{Kind: EKCodeSpan, Text: "c"},
+		&Element{Kind: EKText, Text: " "},
+		&Element{Kind: EKLink, Text: "x", Href: "y"},
+		&Element{Kind: EKImage, Alt: "alt", Href: "img", LineBreak: true}, // last inline in line gets LineBreak
+	)}
 	assertElems(t, got, want)
 }
 
@@ -72,10 +92,10 @@ func TestParseTokens_UnorderedList(t *testing.T) {
 	got := mustParse(t, "- one\n- two\n")
 	want := []*Element{
 		{
-			Kind: EKList, ListKind: ListUnordered,
+			Kind: EKList, ListKind: ListUnordered, Bullet: '-',
 			Children: []*Element{
-				{Kind: EKText, Text: "one", LineBreak: true},
-				{Kind: EKText, Text: "two", LineBreak: true},
+				{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "one", LineBreak: true})}},
+				{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "two", LineBreak: true})}},
 			},
 		},
 	}
@@ -86,10 +106,10 @@ func TestParseTokens_OrderedList(t *testing.T) {
 	got := mustParse(t, "1) one\n2. two\n")
 	want := []*Element{
 		{
-			Kind: EKList, ListKind: ListOrdered,
+			Kind: EKList, ListKind: ListOrdered, Start: 1, Delim: ')',
 			Children: []*Element{
-				{Kind: EKText, Text: "one", LineBreak: true},
-				{Kind: EKText, Text: "two", LineBreak: true},
+				{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "one", LineBreak: true})}},
+				{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "two", LineBreak: true})}},
 			},
 		},
 	}
@@ -101,13 +121,160 @@ func TestParseTokens_Mixed_Blocks(t *testing.T) {
 	got := mustParse(t, src)
 	want := []*Element{
 		{Kind: EKHeading, Level: 3, Text: "Title", LineBreak: true},
-		{Kind: EKList, ListKind: ListUnordered, Children: []*Element{
-			{Kind: EKText, Text: "a", LineBreak: true},
+		{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: []*Element{
+			{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "a", LineBreak: true})}},
 		}},
-		{Kind: EKList, ListKind: ListOrdered, Children: []*Element{
-			{Kind: EKText, Text: "b", LineBreak: true},
+		{Kind: EKList, ListKind: ListOrdered, Start: 1, Delim: ')', Children: []*Element{
+			{Kind: EKListItem, Children: []*Element{para(
+				&Element{Kind: EKText, Text: "b", LineBreak: true},
+				&Element{Kind: EKText, Text: "para", LineBreak: true}, // a lazy continuation of the item
+			)}},
 		}},
-		{Kind: EKText, Text: "para", LineBreak: true},
 	}
 	assertElems(t, got, want)
 }
+
+func TestParseTokens_CodeFence(t *testing.T) {
+	got := mustParse(t, "para\n```go title\nx := *y*\n```\n~~~\n")
+	want := []*Element{
+		para(&Element{Kind: EKText, Text: "para", LineBreak: true}),
+		{Kind: EKCodeBlock, Lang: "go", Fence: "```", Text: "x := *y*", LineBreak: true},
+		{Kind: EKCodeBlock, Fence: "~~~", Text: "", LineBreak: true}, // unterminated runs to EOF
+	}
+	assertElems(t, got, want)
+}
+
+func TestParseTokens_Blockquote(t *testing.T) {
+	got := mustParse(t, "> # T\n> - x\n> > nested\nlazy\n\nafter\n")
+	want := []*Element{
+		{Kind: EKQuote, Children: []*Element{
+			{Kind: EKHeading, Level: 1, Text: "T", LineBreak: true},
+			{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: []*Element{
+				{Kind: EKListItem, Children: []*Element{para(&Element{Kind: EKText, Text: "x", LineBreak: true})}},
+			}},
+			{Kind: EKQuote, Children: []*Element{para(
+				&Element{Kind: EKText, Text: "nested", LineBreak: true},
+				&Element{Kind: EKText, Text: "lazy", LineBreak: true},
+			)}},
+		}},
+		{Kind: EKNewLine, LineBreak: true},
+		para(&Element{Kind: EKText, Text: "after", LineBreak: true}),
+	}
+	assertElems(t, got, want)
+}
+
+func TestParseTokens_HeadingStyles(t *testing.T) {
+	got := mustParse(t, "Title\n===\nSub  \n---\n### Closed ###\n####### seven\n")
+	want := []*Element{
+		{Kind: EKHeading, Level: 1, Style: HeadingSetext, Text: "Title", LineBreak: true},
+		{Kind: EKHeading, Level: 2, Style: HeadingSetext, Text: "Sub", LineBreak: true},
+		{Kind: EKHeading, Level: 3, Style: HeadingATXClosed, Text: "Closed", LineBreak: true},
+		para(&Element{Kind: EKText, Text: "####### seven", LineBreak: true}),
+	}
+	assertElems(t, got, want)
+}
+
+func TestParseTokens_IndentedCode(t *testing.T) {
+	got := mustParse(t, "# T\n\tfunc_name()\n\n      *x*\n\npara\n    lazy\n- item\n\n    continued\n")
+	want := []*Element{
+		{Kind: EKHeading, Level: 1, Text: "T", LineBreak: true},
+		{Kind: EKCodeBlock, Indented: true, Text: "func_name()\n\n  *x*", LineBreak: true},
+		{Kind: EKNewLine, LineBreak: true},
+		para(
+			&Element{Kind: EKText, Text: "para", LineBreak: true},
+			&Element{Kind: EKText, Text: "    lazy", LineBreak: true}, // can't interrupt a paragraph
+		),
+		{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Loose: true, Children: []*Element{
+			{Kind: EKListItem, Children: []*Element{
+				para(&Element{Kind: EKText, Text: "item", LineBreak: true}),
+				{Kind: EKNewLine, LineBreak: true},
+				para(&Element{Kind: EKText, Text: "  continued", LineBreak: true}), // indentation after a list item is not code
+			}},
+		}},
+	}
+	assertElems(t, got, want)
+}
+
+func TestParseTokens_NestedInline(t *testing.T) {
+	got := mustParse(t, "**bold with _italic_ and [link](x)**\n[a `b` _c_](y) d\n")
+	want := []*Element{para(
+		&Element{Kind: EKBold, Delim: '*', LineBreak: true, Children: []*Element{
+			{Kind: EKText, Text: "bold with "},
+			{Kind: EKItalic, Delim: '_', Text: "italic"},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKLink, Text: "link", Href: "x"},
+		}},
+		&Element{Kind: EKLink, Href: "y", Children: []*Element{
+			{Kind: EKText, Text: "a "},
+			{Kind: EKCodeSpan, Text: "b"},
+			{Kind: EKText, Text: " "},
+			{Kind: EKItalic, Delim: '_', Text: "c"},
+		}},
+		&Element{Kind: EKText, Text: " d", LineBreak: true},
+	)}
+	assertElems(t, got, want)
+}
+
+func TestParseTokens_ReferenceLinks(t *testing.T) {
+	l := NewLexer()
+	toks, err := l.Tokenize(strings.NewReader("> [Docs][d] and [D][]\n![d]\n[nope] stays\n\n[d]: https://d.io 'Title'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := NewTokenParser().ParseTokens(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := &Element{Kind: EKLinkDef, Ref: "d", Href: "https://d.io", Title: "Title", LineBreak: true}
+	want := []*Element{
+		{Kind: EKQuote, Children: []*Element{para(
+			&Element{Kind: EKLink, Text: "Docs", Href: "https://d.io", Title: "Title", Ref: "d", LinkStyle: LinkFull},
+			&Element{Kind: EKText, Text: " and "},
+			&Element{Kind: EKLink, Text: "D", Href: "https://d.io", Title: "Title", Ref: "D", LinkStyle: LinkCollapsed, LineBreak: true},
+			&Element{Kind: EKImage, Alt: "d", Href: "https://d.io", Title: "Title", Ref: "d", LinkStyle: LinkShortcut, LineBreak: true},
+			&Element{Kind: EKText, Text: "[nope] stays", LineBreak: true},
+		)}},
+		{Kind: EKNewLine, LineBreak: true},
+		def,
+	}
+	assertElems(t, doc.Elements, want)
+	assertElems(t, []*Element{doc.Definitions["d"]}, []*Element{def})
+}
+
+func TestParseTokens_Autolinks(t *testing.T) {
+	src := "<https://a.io/x_y> and www.b.io/path_(1). or (https://c.io/q?a=1)\nnotwww.d.io nor http://e\n"
+	toks, err := NewLexer().Tokenize(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := NewTokenParser()
+	tp.ExtendedAutolinks = true
+	doc, err := tp.ParseTokens(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Element{para(
+		&Element{Kind: EKLink, Text: "https://a.io/x_y", Href: "https://a.io/x_y", LinkStyle: LinkAuto},
+		&Element{Kind: EKText, Text: " and "},
+		&Element{Kind: EKLink, Text: "www.b.io/path_(1)", Href: "http://www.b.io/path_(1)", LinkStyle: LinkBare},
+		&Element{Kind: EKText, Text: ". or ("},
+		&Element{Kind: EKLink, Text: "https://c.io/q?a=1", Href: "https://c.io/q?a=1", LinkStyle: LinkBare},
+		&Element{Kind: EKText, Text: ")", LineBreak: true},
+		&Element{Kind: EKText, Text: "notwww.d.io nor http://e", LineBreak: true},
+	)}
+	assertElems(t, doc.Elements, want)
+}
+
+func TestParseTokens_BackslashEscapes(t *testing.T) {
+	got := mustParse(t, "\\# x \\*y\\* \\_z\\_ `a\\b` \\\\*\n**a \\* b** \\[n\\](m)\n")
+	want := []*Element{para(
+		&Element{Kind: EKText, Text: "# x *y* _z_ "},
+		&Element{Kind: EKCodeSpan, Text: "a\\b"},
+		&Element{Kind: EKText, Text: " \\*", LineBreak: true},
+		&Element{Kind: EKBold, Delim: '*', Text: "a * b"},
+		&Element{Kind: EKText, Text: " [n](m)", LineBreak: true},
+	)}
+	assertElems(t, got, want)
+}
@@ -3,6 +3,7 @@ package gomd
 import (
 	"context"
 	"strings"
+	"unicode/utf8"
 )
 
 // reset clears the OnePassParser's state, allowing it to be reused for a new parse operation.
@@ -14,18 +15,32 @@ func (p *OnePassParser) reset() {
 	p.err = nil
 }
 
-// reset resets the variableLineCtx to its initial state, clearing pointers and caches.
-func (ctx *variableLineCtx) reset() {
+// reset points the variableLineCtx at a new text, clearing pointers and caches and indexing the special characters.
+func (ctx *variableLineCtx) reset(text string) {
+	ctx.text = text
 	ctx.basePointer = 0
 	ctx.lookAheadPointer = 0
-	ctx.specialChars = []indexChar{}
-	ctx.cache = []byte{}
+	// the buffers are kept for the next line, only the elements are handed on
+	ctx.specialChars = ctx.specialChars[:0]
+	ctx.cache = ctx.cache[:0]
+	ctx.elements = nil
+	ctx.delims = ctx.delims[:0]
+	for i := 0; i < len(text); i++ {
+		// escaped characters are literal, they never open or close anything
+		if text[i] == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]) {
+			i++
+			continue
+		}
+		if strings.IndexByte(ctx.ruleString, text[i]) >= 0 {
+			ctx.specialChars = append(ctx.specialChars, indexChar{i: i, c: rune(text[i])})
+		}
+	}
 }
 
-// seek searches for the next occurrence of a rune in the specialChars slice.
-func (ctx *variableLineCtx) seek(r rune) (found bool) {
+// seek searches the specialChars slice for the next occurrence of a rune at or after the from index.
+func (ctx *variableLineCtx) seek(r rune, from int) (found bool) {
 	for _, indexChar := range ctx.specialChars {
-		if indexChar.c == r {
+		if indexChar.i >= from && indexChar.c == r {
 			ctx.lookAheadPointer = indexChar.i
 			found = true
 			break
@@ -34,6 +49,34 @@ func (ctx *variableLineCtx) seek(r rune) (found bool) {
 	return found
 }
 
+// matchBracket finds the ']' closing the '[' at the open index, skipping over nested bracket pairs.
+func (ctx *variableLineCtx) matchBracket(open int) (int, bool) {
+	depth := 0
+	for _, indexChar := range ctx.specialChars {
+		if indexChar.i <= open {
+			continue
+		}
+		switch indexChar.c {
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return indexChar.i, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
+
+// flushCache checks if there is any cached text in the context and appends it as a text Element.
+func (ctx *variableLineCtx) flushCache() {
+	if len(ctx.cache) != 0 {
+		ctx.elements = append(ctx.elements, &Element{Kind: EKText, Text: string(ctx.cache)})
+		ctx.cache = ctx.cache[:0]
+	}
+}
+
 // canceled checks if the context has been canceled or has an error.
 func (p *OnePassParser) canceled() bool {
 	if p.ctx == nil {
@@ -51,14 +94,6 @@ func (p *OnePassParser) appendElement(e *Element) {
 	*p.leafNode = append(*p.leafNode, e)
 }
 
-// flushCtxCache checks if there is any cached text in the lineCtx and appends it as a text Element.
-func (p *OnePassParser) flushCtxCache() {
-	if len(p.lineCtx.cache) != 0 {
-		p.appendElement(&Element{Kind: EKText, Text: string(p.lineCtx.cache)})
-		p.lineCtx.cache = []byte{}
-	}
-}
-
 // Parse parses the provided Markdown string and returns a slice of Elements.
 func (p *OnePassParser) Parse(md string) *Document {
 	document, _ := p.ParseCtx(context.Background(), md)
@@ -66,10 +101,22 @@ func (p *OnePassParser) Parse(md string) *Document {
 }
 
 // ParseCtx parses the provided Markdown string in the context of the provided context.Context.
+// Link reference definitions are collected from the whole text first, so links can refer to definitions further down.
 func (p *OnePassParser) ParseCtx(ctx context.Context, md string) (*Document, error) {
+	lines := strings.Split(md, "\n")
+	doc, err := p.parseLinesCtx(ctx, lines, collectDefinitions(lines))
+	if err != nil {
+		return nil, err
+	}
+	linkFootnotes(doc.Definitions, doc.Elements)
+	return doc, nil
+}
+
+// parseLinesCtx parses the lines into a Document, resolving reference links against defs.
+func (p *OnePassParser) parseLinesCtx(ctx context.Context, lines []string, defs map[string]*Element) (*Document, error) {
 	p.ctx = ctx
 	p.reset()
-	lines := strings.Split(md, "\n")
+	p.defs = defs
 	nestCount := 0
 
 	for i := 0; i < len(lines); i++ {
@@ -80,83 +127,146 @@ func (p *OnePassParser) ParseCtx(ctx context.Context, md string) (*Document, err
 		p.text = lines[i]
 		if len(p.text) == 0 && i < len(lines)-1 {
 			// is the next line a rule?
-			if i <= len(lines)-1 && len(lines) > 0 && lines[i+1] == "---" {
+			if i <= len(lines)-1 && len(lines) > 0 && isRule(lines[i+1]) {
+				continue
+			}
+			if p.processLooseBlank(lines, &i) {
 				continue
 			}
+			// any other blank line ends the lists
+			p.parentStack = (p.parentStack)[:0]
+			nestCount = 0
+			p.leafNode = &p.elements
 
 			p.appendElement(&Element{Kind: EKNewLine, LineBreak: true})
 		}
 
+		if p.processIndentedCode(lines, &i) {
+			p.parentStack = (p.parentStack)[:0]
+			nestCount = 0
+			continue
+		}
+
 		// we allow for switching between the elements and Children slices
-		isListItem, generation, listType := p.identifyListedItem()
+		isListItem, generation, marker := p.identifyListedItem()
 		if isListItem {
-			nestCount = p.handleListItem(listType, nestCount, generation)
+			nestCount = p.handleListItem(marker, nestCount, generation)
+			if p.processListItem(lines, &i) {
+				continue
+			}
 		} else {
 			p.parentStack = (p.parentStack)[:0]
 			nestCount = 0
 			p.leafNode = &p.elements
 		}
 
-		if p.processHeader() ||
-			p.processHorizontalRule(&i) ||
-			p.processVariableLine() {
+		if p.processCodeFence(lines, &i) ||
+			p.processHTMLBlock(lines, &i) ||
+			p.processQuote(lines, &i) ||
+			p.processHeader() ||
+			p.processFootnoteDef(lines, &i) ||
+			p.processLinkDef() ||
+			p.processTable(lines, &i) ||
+			p.processSetextHeader(lines, &i) ||
+			p.processHorizontalRule(lines, &i) ||
+			p.processVariableLine(lines, &i) {
 			continue
 		}
 	}
 
-	return &Document{Elements: p.elements}, nil
+	groupListItems(p.elements)
+	p.elements = groupParagraphs(p.elements)
+	return &Document{Elements: p.elements, Definitions: p.defs}, nil
 }
 
 // identifyListedItem checks if the current line starts with a list item marker (either unordered or ordered).
-func (p *OnePassParser) identifyListedItem() (bool, int, ListType) {
+func (p *OnePassParser) identifyListedItem() (bool, int, listMarker) {
 	trimmed := strings.TrimLeft(p.text, " \t")
-	listType := ListNone
-
-	if len(trimmed) >= 2 && trimmed[0] == '-' && trimmed[1] == ' ' {
-		listType = ListUnordered
+	marker, n, ok := parseListMarker(trimmed)
+	if !ok || n == len(trimmed) || isRule(p.text) {
+		return false, 0, listMarker{}
 	}
 
-	// TODO: update for parsing lists longer than 9
-	if len(trimmed) >= 3 &&
-		strings.Contains("123456789", string(trimmed[0])) &&
-		trimmed[1] == '.' &&
-		trimmed[2] == ' ' {
-		listType = ListOrdered
+	generation := listGeneration(p.text)
+	p.text = trimmed[n+1:]
+	return true, generation, marker
+}
+
+// listGeneration returns the nesting level of a list item line, one level per 2 columns of indentation.
+func listGeneration(line string) int {
+	spaceCount, _ := leadingIndent(line)
+	return (spaceCount / 2) + 1
+}
+
+// processListItem checks if the list item goes on past its first line, with indented or lazy continuation lines,
+// opens with a task box or holds something else than a line of inline text. If so, the lines of the item are stripped of its indentation (and the box) and parsed
+// on their own to become the children of an EKListItem. A blank line inside the item makes the list loose.
+func (p *OnePassParser) processListItem(lines []string, index *int) bool {
+	indent, rest := leadingIndent(lines[*index])
+	contentCol := indent + len(rest) - len(p.text)
+	end := itemContinuation(lines, *index, p.text, contentCol)
+	checked, text, task := parseTaskBox(p.text)
+	// a line of inline text is left to the list
+	if end == *index+1 && !task && isInlineItem(p.text) {
+		return false
 	}
 
-	if listType == ListNone {
-		return false, 0, ListNone
+	inner := []string{text}
+	for _, line := range lines[*index+1 : end] {
+		inner = append(inner, stripIndent(line, contentCol))
 	}
 
-	spaceCount := 0
-	for _, r := range p.text {
-		if string(r) == "\t" {
-			spaceCount += 2
-		} else if r == ' ' {
-			spaceCount += 1
-		} else {
-			break
-		}
+	sub := NewOnePassParser()
+	sub.ExtendedAutolinks = p.ExtendedAutolinks
+	doc, err := sub.parseLinesCtx(p.ctx, inner, p.defs)
+	if err != nil {
+		p.err = err
+		return true
+	}
+	p.appendElement(&Element{Kind: EKListItem, Task: task, Checked: checked, Children: doc.Elements})
+	if hasBlankLine(inner[1:]) {
+		p.parentStack[len(p.parentStack)-1].Loose = true
 	}
+	*index = end - 1
+	return true
+}
 
-	if listType == ListUnordered {
-		trimmed = trimmed[2:]
-	} else {
-		trimmed = trimmed[3:]
+// processLooseBlank checks if the blank line at index is followed, after any other blank lines, by another item of
+// an open list. If so, the blank lines are skipped and the list is marked loose.
+func (p *OnePassParser) processLooseBlank(lines []string, index *int) bool {
+	if len(p.parentStack) == 0 {
+		return false
+	}
+	next := *index + 1
+	for next < len(lines) && onlySpaces(lines[next]) {
+		next++
+	}
+	if next == len(lines) || !isAnyListMarkerLine(lines[next]) {
+		return false
 	}
 
-	p.text = trimmed
-	return true, (spaceCount / 2) + 1, listType
+	// a deeper item makes the innermost list loose, an item at the level of an open list must continue that list
+	list := p.parentStack[len(p.parentStack)-1]
+	if generation := listGeneration(lines[next]); generation <= len(p.parentStack) {
+		list = p.parentStack[generation-1]
+		marker, _, _ := parseListMarker(strings.TrimLeft(lines[next], " \t"))
+		if list.ListKind != marker.kind || list.Bullet != marker.bullet {
+			return false
+		}
+	}
+	list.Loose = true
+	*index = next - 1
+	return true
 }
 
 // handleListItem processes a list item based on its type and nesting level.
-func (p *OnePassParser) handleListItem(listType ListType, nestCount int, generation int) int {
+func (p *OnePassParser) handleListItem(marker listMarker, nestCount int, generation int) int {
 	if nestCount < generation {
 		var targetParent *Element
 		var rootParent *Element
 		// create as many parents as required and link them in lineage order, and keep a pointer to the root parent
 		for i := 0; i < generation-nestCount; i++ {
-			parent := &Element{Kind: EKList, ListKind: listType, Children: []*Element{}}
+			parent := marker.list()
 			if i == 0 {
 				rootParent = parent
 			} else {
@@ -183,382 +293,550 @@ func (p *OnePassParser) handleListItem(listType ListType, nestCount int, generat
 		p.parentStack = (p.parentStack)[:len(p.parentStack)-1]
 	}
 
+	// a different kind of marker at the same level ends the list and starts a new one
+	if top := p.parentStack[len(p.parentStack)-1]; top.ListKind != marker.kind || top.Bullet != marker.bullet {
+		p.parentStack = p.parentStack[:len(p.parentStack)-1]
+		p.leafNode = &p.elements
+		if len(p.parentStack) > 0 {
+			p.leafNode = &(p.parentStack)[len(p.parentStack)-1].Children
+		}
+		list := marker.list()
+		p.appendElement(list)
+		p.parentStack = append(p.parentStack, list)
+	}
+
 	p.leafNode = &(p.parentStack)[len(p.parentStack)-1].Children
 
-	return nestCount
+	// the lists nested deeper than the item are closed
+	return generation
 }
 
-// processHeader determines if the line has a valid header by counting hashes and checking for a space that follows immediately.
-// it returns true and appends the Element pointer to the dereferenced *[]*Element slice if it identifies a valid header.
-// it returns false and does nothing if no valid header is found.
-// it identifies the header type based on the hash count
-func (p *OnePassParser) processHeader() bool {
-	if !strings.HasPrefix(p.text, "#") {
+// processCodeFence checks if the line opens a fenced code block and, if so, consumes every line up to and including the closing fence.
+// An unterminated fence runs to the end of the document.
+func (p *OnePassParser) processCodeFence(lines []string, index *int) bool {
+	fence, ok := parseFenceOpen(p.text)
+	if !ok {
 		return false
 	}
-	level := 0
-	isHeader := true
 
-	for i, r := range p.text {
-		if r == '#' {
-			level = i + 1
-		} else if level > 0 {
-			if r != ' ' {
-				isHeader = false
-			}
+	code := []string{}
+	i := *index + 1
+	for ; i < len(lines); i++ {
+		if p.canceled() {
+			return true
+		}
+		if fence.closes(lines[i]) {
 			break
-		} else {
-			isHeader = false
 		}
+		// the last line after a trailing newline is not part of the content
+		if i == len(lines)-1 && lines[i] == "" {
+			break
+		}
+		code = append(code, fence.content(lines[i]))
 	}
 
-	if isHeader {
-		p.appendElement(&Element{Kind: EKHeading, Level: level, LineBreak: true, Text: strings.TrimRight(p.text[level+1:], " ")})
-	}
-	return isHeader
+	p.appendElement(&Element{
+		Kind:      EKCodeBlock,
+		Lang:      fence.lang,
+		Fence:     fence.fence(),
+		Text:      strings.Join(code, "\n"),
+		LineBreak: true,
+	})
+	*index = i
+	return true
 }
 
-// processHorizontalRule checks if the line starts with "---" and contains only valid characters.
-func (p *OnePassParser) processHorizontalRule(index *int) bool {
-	if !strings.HasPrefix(p.text, "---") {
+// processHTMLBlock checks if the line opens an HTML block and, if so, consumes its lines, which are kept verbatim.
+// A block opened by a block-level tag or a lone tag runs to the next blank line, the others to the line holding their end,
+// such as "-->" for a comment. A lone tag can't interrupt a paragraph.
+func (p *OnePassParser) processHTMLBlock(lines []string, index *int) bool {
+	afterPara := *index > 0 && isParagraphLine(lines[*index-1])
+	cond := htmlBlockStart(p.text, afterPara)
+	if cond == 0 {
 		return false
 	}
 
-	invalidChar := strings.ContainsFunc(p.text, func(r rune) bool {
-		if r != ' ' && r != '-' {
-			return true
+	block := []string{p.text}
+	i := *index
+	for ; !htmlBlockEnds(cond, lines[i]) && i+1 < len(lines); i++ {
+		next := lines[i+1]
+		// the last line after a trailing newline is not part of the block
+		if (cond >= 6 && onlySpaces(next)) || (i+1 == len(lines)-1 && next == "") {
+			break
 		}
-		return false
-	})
-
-	if !invalidChar {
-		p.appendElement(&Element{Kind: EKRule, LineBreak: true, Text: "\n" + p.text + "\n"})
-		*index = *index + 1
+		block = append(block, next)
 	}
-	return !invalidChar
+
+	p.appendElement(&Element{Kind: EKHTMLBlock, Text: strings.Join(block, "\n"), LineBreak: true})
+	*index = i
+	return true
 }
 
-// processVariableLine processes a line of text for Markdown syntax elements such as bold, italic, links, images, and code spans.
-func (p *OnePassParser) processVariableLine() bool {
-	p.lineCtx.reset()
-	for i, r := range p.text {
-		if strings.Contains(p.lineCtx.ruleString, string(r)) {
-			p.lineCtx.specialChars = append(p.lineCtx.specialChars, indexChar{i: i, c: r})
-		}
+// processIndentedCode checks if the line is indented by four or more columns where it can't be a paragraph or list continuation,
+// and if so, consumes it and the following indented lines as an indented code block.
+// Blank lines inside the block are kept, trailing blank lines are left for the main loop.
+func (p *OnePassParser) processIndentedCode(lines []string, index *int) bool {
+	first, ok := stripCodeIndent(p.text)
+	if !ok || onlySpaces(first) || !indentedCodeCanStart(lines, *index) {
+		return false
 	}
 
-	for ; p.lineCtx.basePointer < len(p.text)-1; p.lineCtx.basePointer++ {
-		char := string(p.text[p.lineCtx.basePointer])
-		if strings.Contains(p.lineCtx.ruleString, char) {
-			switch char {
-			case "*":
-				p.handleBold(&p.lineCtx)
-				continue
-			case "_":
-				p.handleItalic(&p.lineCtx)
-				continue
-			case "[":
-				p.handleLink(&p.lineCtx)
-				continue
-			case "!":
-				p.handleImage(&p.lineCtx)
-				continue
-			case "`":
-				p.handleCode(&p.lineCtx)
-				continue
-			}
+	code := []string{first}
+	pending := []string{}
+	end := *index
+	for i := *index + 1; i < len(lines); i++ {
+		rest, ok := stripCodeIndent(lines[i])
+		if onlySpaces(lines[i]) {
+			pending = append(pending, rest)
+			continue
 		}
-		p.lineCtx.cache = append(p.lineCtx.cache, p.text[p.lineCtx.basePointer])
-	}
-	if len(p.lineCtx.cache) != 0 || len(p.text) == 1 {
-		last := ""
-		if len(p.text) > 0 {
-			last = string(p.text[len(p.text)-1])
+		if !ok {
+			break
 		}
-		p.appendElement(&Element{Kind: EKText, LineBreak: true, Text: string(p.lineCtx.cache) + last})
+		code = append(code, pending...)
+		code = append(code, rest)
+		pending = pending[:0]
+		end = i
 	}
 
-	return false
+	// the element goes to the top level, indented code is never part of a list
+	p.leafNode = &p.elements
+	p.appendElement(&Element{Kind: EKCodeBlock, Indented: true, Text: strings.Join(code, "\n"), LineBreak: true})
+	*index = end
+	return true
 }
 
-// handleBold processes bold text enclosed in double asterisks "**...**".
-func (p *OnePassParser) handleBold(ctx *variableLineCtx) {
-	if p.err != nil {
-		return
+// indentedCodeCanStart checks the lines before i: an indented code block can't interrupt a paragraph,
+// and indentation following a list item (even after blank lines) belongs to the list.
+func indentedCodeCanStart(lines []string, i int) bool {
+	if i == 0 {
+		return true
 	}
-	if p.text[ctx.basePointer+1] != '*' {
-		return
+	if prev := lines[i-1]; !onlySpaces(prev) {
+		return !isParagraphLine(prev) && !isListMarkerLine(prev)
 	}
-
-	ctx.specialChars = ctx.specialChars[2:]
-
-	if !ctx.seek('*') || p.text[ctx.lookAheadPointer+1] != '*' {
-		return
+	for j := i - 1; j >= 0; j-- {
+		if !onlySpaces(lines[j]) {
+			return !isListMarkerLine(lines[j])
+		}
 	}
+	return true
+}
 
-	// now we have a range of text in the bold between the basePointer and lookAheadPointer that is bold
-	boldText := p.text[ctx.basePointer+2 : ctx.lookAheadPointer]
-	// clear the cache to a text element
-	p.flushCtxCache()
-	// if there are no more chars then we use the ln version
-	if ctx.lookAheadPointer+1 == len(p.text)-1 {
-		p.appendElement(&Element{Kind: EKBold, LineBreak: true, Text: inlineWrap("**", boldText)})
-	} else {
-		p.appendElement(&Element{Kind: EKBold, Text: inlineWrap("**", boldText)})
+// processQuote checks if the line opens a blockquote and, if so, consumes the quoted lines and any lazy continuation lines.
+// The quoted text is stripped of its markers and parsed on its own to become the children of the quote. Quotes that
+// hold nothing but another quote are stripped along with it, and their text is parsed once for all of them.
+func (p *OnePassParser) processQuote(lines []string, index *int) bool {
+	rest, ok := stripQuoteMarker(p.text)
+	if !ok {
+		return false
 	}
 
-	// remove the further double * from specialChars
-	newSpecialChars := []indexChar{}
-	count := 0
-	for i, specialChar := range ctx.specialChars {
-		if count == 2 {
-			newSpecialChars = append(newSpecialChars, ctx.specialChars[i:]...)
+	inner := []string{rest}
+	quoted := []bool{true}
+	prev := rest
+	i := *index + 1
+	for ; i < len(lines); i++ {
+		rest, ok := stripQuoteMarker(lines[i])
+		if !ok && !lazyContinues(prev, lines[i]) {
 			break
 		}
-		if specialChar.c == '*' {
-			count++
-		} else {
-			newSpecialChars = append(newSpecialChars, specialChar)
-		}
+		inner = append(inner, rest)
+		quoted = append(quoted, ok)
+		prev = rest
 	}
-	ctx.specialChars = newSpecialChars
-	// we can increment the lookaheadpointer by one as it is currently targeting the first closing *
-	ctx.lookAheadPointer = ctx.lookAheadPointer + 1
-	// shift the pointer up, it gets incremented by 1 in the loop
-	ctx.basePointer = ctx.lookAheadPointer
+	depth, _ := nestedQuotes(inner, quoted)
+
+	sub := NewOnePassParser()
+	sub.ExtendedAutolinks = p.ExtendedAutolinks
+	doc, err := sub.parseLinesCtx(p.ctx, inner, p.defs)
+	if err != nil {
+		p.err = err
+		return true
+	}
+	p.appendElement(nestQuote(doc.Elements, depth))
+	// the loop increments the index past the last quoted line
+	*index = i - 1
+	return true
 }
 
-// handleItalic processes italic text enclosed in single underscores "_..._".
-func (p *OnePassParser) handleItalic(ctx *variableLineCtx) {
-	if p.err != nil {
-		return
+// processHeader determines if the line has a valid header by counting hashes and checking for a space that follows immediately.
+// it returns true and appends the Element pointer to the dereferenced *[]*Element slice if it identifies a valid header.
+// it returns false and does nothing if no valid header is found.
+// it identifies the header type based on the hash count
+func (p *OnePassParser) processHeader() bool {
+	if !strings.HasPrefix(p.text, "#") {
+		return false
+	}
+
+	level, text, closed, isHeader := parseATXHeading(p.text)
+	if isHeader {
+		style := HeadingATX
+		if closed {
+			style = HeadingATXClosed
+		}
+		p.appendElement(p.headingElement(level, style, text))
 	}
-	// let's unshift 1 from specialChars
-	ctx.specialChars = ctx.specialChars[1:]
+	return isHeader
+}
 
-	if !ctx.seek('_') {
-		return
+// processFootnoteDef checks if the line opens a footnote definition "[^label]: text". Its text and the lines indented
+// under it are parsed as a document of their own, as list items are, into the blocks of the definition.
+func (p *OnePassParser) processFootnoteDef(lines []string, index *int) bool {
+	if len(p.parentStack) != 0 {
+		return false
+	}
+	label, text, ok := parseFootnoteDef(p.text)
+	if !ok {
+		return false
 	}
 
-	italicText := p.text[ctx.basePointer+1 : ctx.lookAheadPointer]
-	// clear the cache to a text element
+	inner, end := footnoteLines(lines, *index, text)
+	sub := NewOnePassParser()
+	sub.ExtendedAutolinks = p.ExtendedAutolinks
+	doc, err := sub.parseLinesCtx(p.ctx, inner, p.defs)
+	if err != nil {
+		p.err = err
+		return true
+	}
+	p.appendElement(&Element{Kind: EKFootnoteDef, Ref: label, LineBreak: true, Children: doc.Elements})
+	*index = end - 1
+	return true
+}
 
-	p.flushCtxCache()
-	// if there are no more chars we use the ln version
-	if ctx.lookAheadPointer == len(p.text)-1 {
-		p.appendElement(&Element{Kind: EKItalic, LineBreak: true, Text: inlineWrap("_", italicText)})
-	} else {
-		p.appendElement(&Element{Kind: EKItalic, Text: inlineWrap("_", italicText)})
+// processLinkDef checks if the line is a link reference definition "[label]: href". The definition was already
+// collected before parsing, it is kept in place as an element so that it renders back where it was.
+func (p *OnePassParser) processLinkDef() bool {
+	def, ok := parseLinkDef(p.text)
+	if ok {
+		p.appendElement(def)
 	}
+	return ok
+}
 
-	// let's remove special chars that are no more of use to us
-	newSpecialChars := []indexChar{}
-	count := 0
-	for i, specialChar := range ctx.specialChars {
-		if count == 1 {
-			newSpecialChars = append(newSpecialChars, ctx.specialChars[i:]...)
-			break
-		}
-		if specialChar.c == '_' {
-			count++
-		} else {
-			newSpecialChars = append(newSpecialChars, specialChar)
-		}
+// processSetextHeader checks if the next line underlines the current one with "===" or "---", making it a setext heading.
+// Only a plain paragraph line outside of lists can be underlined, so list items followed by "---" stay a list and a rule.
+func (p *OnePassParser) processSetextHeader(lines []string, index *int) bool {
+	if *index+1 >= len(lines) || len(p.parentStack) != 0 || startsBlock(p.text) {
+		return false
+	}
+	level := setextLevel(lines[*index+1])
+	if level == 0 {
+		return false
 	}
 
-	ctx.specialChars = newSpecialChars
-	// shift the pointer up, it gets incremented by 1 in the loop
-	ctx.basePointer = ctx.lookAheadPointer
+	p.appendElement(p.headingElement(level, HeadingSetext, strings.TrimSpace(p.text)))
+	*index = *index + 1
+	return true
 }
 
-// handleItalic processes italic text enclosed in single underscores "_..._".
-func (p *OnePassParser) handleLink(ctx *variableLineCtx) {
-	if p.err != nil {
-		return
+// processTable checks if the line and the next one are the header and delimiter rows of a table and, if so,
+// consumes them and the body rows after them, up to a blank line or the start of another block.
+// The cells are parsed for inline markup like any other line. Only a table outside of lists is parsed here.
+func (p *OnePassParser) processTable(lines []string, index *int) bool {
+	if *index+1 >= len(lines) || len(p.parentStack) != 0 {
+		return false
+	}
+	align, ok := tableStart(p.text, lines[*index+1])
+	if !ok {
+		return false
 	}
-	// let's unshift 1 from specialChars
-	ctx.specialChars = ctx.specialChars[1:]
 
-	found := false
-	tempLookAheadPointer := 0
-	for _, indexChar := range ctx.specialChars {
-		if indexChar.c == ']' {
-			found = true
-			tempLookAheadPointer = indexChar.i
-			break
-		}
+	table := &Element{Kind: EKTable, Align: align, LineBreak: true}
+	table.Children = append(table.Children, tableRow(p.parseCells(p.text), len(align)))
+	i := *index + 2
+	for ; i < len(lines) && continuesTable(lines[i]); i++ {
+		table.Children = append(table.Children, tableRow(p.parseCells(lines[i]), len(align)))
 	}
-	if !found || string(p.text[tempLookAheadPointer+1]) != "(" {
-		return
+	p.appendElement(table)
+	*index = i - 1
+	return true
+}
+
+// parseCells parses the inline content of each cell of a table row.
+func (p *OnePassParser) parseCells(line string) [][]*Element {
+	var cells [][]*Element
+	for _, c := range tableCells(line) {
+		cells = append(cells, p.parseInline(unescapePipes(line[c[0]:c[1]])))
 	}
-	// ok we have a square brackets enclosed text
-	display := p.text[ctx.basePointer+1 : tempLookAheadPointer]
+	return cells
+}
 
-	// continue the same again but for parenthesis
-	foundLink := false
-	for _, indexChar := range ctx.specialChars {
-		if indexChar.i <= tempLookAheadPointer+1 {
-			continue
-		}
-		if indexChar.c == ')' {
-			foundLink = true
-			ctx.lookAheadPointer = indexChar.i
-			break
-		}
+// headingElement builds a heading, its text is parsed for inline markup like any other line.
+func (p *OnePassParser) headingElement(level int, style HeadingStyle, text string) *Element {
+	el := spanElement(EKHeading, p.parseInline(text))
+	el.Level = level
+	el.Style = style
+	el.LineBreak = true
+	return el
+}
+
+// processHorizontalRule checks if the line is a rule of '-', '*' or '_'. The blank line written after a rule is consumed with it.
+func (p *OnePassParser) processHorizontalRule(lines []string, index *int) bool {
+	if !isRule(p.text) {
+		return false
 	}
 
-	if !foundLink {
-		return
+	p.appendElement(&Element{Kind: EKRule, LineBreak: true, Fence: strings.TrimSpace(p.text)})
+	if *index+1 < len(lines) && onlySpaces(lines[*index+1]) {
+		*index = *index + 1
 	}
+	return true
+}
 
-	// we definitely have a link
-	link := p.text[tempLookAheadPointer+2 : ctx.lookAheadPointer]
-	// flush the cache
-	p.flushCtxCache()
-	// if there are no more chars we use the ln version
-	if ctx.lookAheadPointer == len(p.text)-1 {
-		p.appendElement(&Element{Kind: EKLink, LineBreak: true, Text: display, Href: link})
-	} else {
-		p.appendElement(&Element{Kind: EKLink, Text: display, Href: link})
-	}
-	// let's clear out the special chars that are of no use to us
-	newSpecialChars := []indexChar{}
-	count := 0
-	foundClosingSquare := false
-	for i, specialChar := range ctx.specialChars {
-		if count == 1 {
-			newSpecialChars = append(newSpecialChars, ctx.specialChars[i:]...)
-			break
+// processVariableLine processes a line of text for Markdown syntax elements such as bold, italic, links, images, and code spans.
+// A line ending in two spaces or a backslash, followed by more text of the paragraph, ends with a hard break.
+func (p *OnePassParser) processVariableLine(lines []string, index *int) bool {
+	cont := *index+1 < len(lines) && isParagraphLine(lines[*index+1])
+	text, brk := splitHardBreak(p.text, cont)
+	p.lineCtx.reset(text)
+	p.scanInline(&p.lineCtx)
+
+	// the last element of the line carries the line break
+	if brk != "" {
+		p.lineCtx.elements = append(p.lineCtx.elements, &Element{Kind: EKHardBreak, Fence: brk, LineBreak: true})
+	} else if n := len(p.lineCtx.elements); n > 0 {
+		p.lineCtx.elements[n-1].LineBreak = true
+	}
+	for _, e := range p.lineCtx.elements {
+		p.appendElement(e)
+	}
+	return false
+}
+
+// parseInline parses a span of inline text, such as the inside of bold text, with a context of its own.
+func (p *OnePassParser) parseInline(text string) []*Element {
+	ctx := &variableLineCtx{ruleString: p.lineCtx.ruleString}
+	ctx.reset(text)
+	p.scanInline(ctx)
+	return ctx.elements
+}
+
+// scanInline walks the text of the context, handing special characters to their handlers and caching plain text.
+func (p *OnePassParser) scanInline(ctx *variableLineCtx) {
+	for ctx.basePointer < len(ctx.text) {
+		if p.err != nil {
+			return
 		}
-		if specialChar.c == ']' {
-			foundClosingSquare = true
-		} else if specialChar.c == ')' && foundClosingSquare {
-			count++
-		} else if strings.Contains(string(specialChar.c), "[]()") {
-			newSpecialChars = append(newSpecialChars, specialChar)
+		handled := false
+		switch ctx.text[ctx.basePointer] {
+		case '\\':
+			handled = p.handleEscape(ctx)
+		case '&':
+			handled = p.handleEntity(ctx)
+		case '*', '_', '~':
+			handled = p.handleDelimRun(ctx)
+		case '[':
+			handled = p.handleFootnoteRef(ctx) || p.handleLink(ctx)
+		case '!':
+			handled = p.handleImage(ctx)
+		case '`':
+			handled = p.handleCode(ctx)
+		case '<':
+			handled = p.handleAutolink(ctx) || p.handleHTML(ctx)
+		case 'w', 'h':
+			handled = p.ExtendedAutolinks && p.handleBareURL(ctx)
 		}
+		if handled {
+			continue
+		}
+		ctx.cache = append(ctx.cache, ctx.text[ctx.basePointer])
+		ctx.basePointer++
 	}
-	ctx.specialChars = newSpecialChars
-	// shift the pointer up, it gets incremented by 1 in the loop
-	ctx.basePointer = ctx.lookAheadPointer + 1
+	ctx.flushCache()
+	ctx.elements = resolveEmphasis(ctx.elements, ctx.delims)
 }
 
-// handleImage processes images in Markdown syntax, which are similar to links but start with an exclamation mark.
-func (p *OnePassParser) handleImage(ctx *variableLineCtx) {
-	if p.err != nil {
-		return
+// handleEscape processes a backslash escape, the escaped punctuation is cached as literal text.
+func (p *OnePassParser) handleEscape(ctx *variableLineCtx) bool {
+	if ctx.basePointer+1 >= len(ctx.text) || !isASCIIPunct(ctx.text[ctx.basePointer+1]) {
+		return false
 	}
+	ctx.cache = append(ctx.cache, ctx.text[ctx.basePointer+1])
+	ctx.basePointer += 2
+	return true
+}
 
-	ctx.specialChars = ctx.specialChars[1:]
+// handleEntity processes an entity or numeric character reference, such as "&copy;" or "&#169;",
+// the character it stands for is cached as literal text.
+func (p *OnePassParser) handleEntity(ctx *variableLineCtx) bool {
+	text, n := parseEntity(ctx.text[ctx.basePointer:])
+	if n == 0 {
+		return false
+	}
+	ctx.cache = append(ctx.cache, text...)
+	ctx.basePointer += n
+	return true
+}
 
-	if string(p.text[ctx.basePointer+1]) != "[" {
-		return
+// handleDelimRun processes a run of '*' or '_' characters, which may open or close bold and italic text,
+// or of '~' characters, which may open or close struck text.
+// The runs of the line are matched once it has been scanned, see resolveEmphasis.
+func (p *OnePassParser) handleDelimRun(ctx *variableLineCtx) bool {
+	char := ctx.text[ctx.basePointer]
+	end := ctx.basePointer
+	for end < len(ctx.text) && ctx.text[end] == char {
+		end++
+	}
+	before, _ := utf8.DecodeLastRuneInString(ctx.text[:ctx.basePointer])
+	after, _ := utf8.DecodeRuneInString(ctx.text[end:])
+	if ctx.basePointer == 0 {
+		before = ' '
+	}
+	if end == len(ctx.text) {
+		after = ' '
+	}
+
+	run := newDelimRun(char, end-ctx.basePointer, before, after)
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, run.el)
+	ctx.delims = append(ctx.delims, run)
+	ctx.basePointer = end
+	return true
+}
+
+// handleLink processes links "[display](href)" and reference links "[display][ref]", "[ref][]" and "[ref]".
+// The display text is parsed again, so it can hold emphasis and images.
+// A reference link is only a link if its label is defined, otherwise the brackets are literal text.
+func (p *OnePassParser) handleLink(ctx *variableLineCtx) bool {
+	closing, ok := ctx.matchBracket(ctx.basePointer)
+	if !ok {
+		return false
 	}
 
-	// so we have an ! and a [
-	found := false
-	tempLookAheadPointer := 0
-	for _, indexChar := range ctx.specialChars {
-		if indexChar.c == ']' {
-			found = true
-			tempLookAheadPointer = indexChar.i
-			break
-		}
+	display := ctx.text[ctx.basePointer+1 : closing]
+	var el *Element
+	end := 0
+	if closing+1 < len(ctx.text) && ctx.text[closing+1] == '(' && ctx.seek(')', closing+2) {
+		el = &Element{Kind: EKLink, Href: unescapeText(ctx.text[closing+2 : ctx.lookAheadPointer])}
+		end = ctx.lookAheadPointer + 1
+	} else if label, style, def, n := p.lookupRef(display, ctx.text[closing+1:]); def != nil {
+		el = &Element{Kind: EKLink, Href: def.Href, Title: def.Title, Ref: label, LinkStyle: style}
+		end = closing + 1 + n
+	} else {
+		return false
 	}
 
-	if !found || string(p.text[tempLookAheadPointer+1]) != "(" {
-		return
+	// we definitely have a link
+	ctx.flushCache()
+	children := p.parseInline(display)
+	if isPlainText(children) {
+		el.Text = plainText(children)
+	} else {
+		el.Children = children
 	}
+	ctx.elements = append(ctx.elements, el)
 
-	// ok we have a square brackets enclosed text
-	alt := p.text[ctx.basePointer+2 : tempLookAheadPointer]
+	// shift the pointer past the link
+	ctx.basePointer = end
+	return true
+}
 
-	// continue the same again but for parenthesis
-	foundImage := false
+// handleFootnoteRef processes a footnote reference "[^label]" to a footnote defined in the document.
+// Without a definition the brackets are literal text.
+func (p *OnePassParser) handleFootnoteRef(ctx *variableLineCtx) bool {
+	label, n := parseFootnoteRef(ctx.text[ctx.basePointer:])
+	if n == 0 || p.defs[footnoteKey(label)] == nil {
+		return false
+	}
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, &Element{Kind: EKFootnoteRef, Ref: label})
+	ctx.basePointer += n
+	return true
+}
 
-	for _, indexChar := range ctx.specialChars {
-		if indexChar.i <= tempLookAheadPointer+1 {
-			continue
-		}
-		if indexChar.c == ')' {
-			foundImage = true
-			ctx.lookAheadPointer = indexChar.i
-			break
-		}
+// lookupRef checks if the text of a link or image, followed by rest, forms a reference to a defined label.
+// It returns the label as written, the reference style, the definition (nil if the label is not defined)
+// and the number of bytes of rest taken by the reference.
+func (p *OnePassParser) lookupRef(text, rest string) (string, LinkStyle, *Element, int) {
+	label, style, n := refSuffix(text, rest)
+	return label, style, linkDef(p.defs, label), n
+}
+
+// handleImage processes images in Markdown syntax, which are similar to links but start with an exclamation mark.
+func (p *OnePassParser) handleImage(ctx *variableLineCtx) bool {
+	if ctx.basePointer+1 >= len(ctx.text) || ctx.text[ctx.basePointer+1] != '[' {
+		return false
+	}
+	closing, ok := ctx.matchBracket(ctx.basePointer + 1)
+	if !ok {
+		return false
 	}
 
-	if !foundImage {
-		return
+	alt := unescapeText(ctx.text[ctx.basePointer+2 : closing])
+	var el *Element
+	if closing+1 < len(ctx.text) && ctx.text[closing+1] == '(' && ctx.seek(')', closing+2) {
+		el = &Element{Kind: EKImage, Alt: alt, Href: unescapeText(ctx.text[closing+2 : ctx.lookAheadPointer])}
+		ctx.basePointer = ctx.lookAheadPointer + 1
+	} else if label, style, def, n := p.lookupRef(ctx.text[ctx.basePointer+2:closing], ctx.text[closing+1:]); def != nil {
+		el = &Element{Kind: EKImage, Alt: alt, Href: def.Href, Title: def.Title, Ref: label, LinkStyle: style}
+		ctx.basePointer = closing + 1 + n
+	} else {
+		return false
 	}
 
-	// we have
-	href := p.text[tempLookAheadPointer+2 : ctx.lookAheadPointer]
-	// flush the cache to a text element
-	p.flushCtxCache()
-	// images are always "ln"
-	p.appendElement(&Element{Kind: EKImage, LineBreak: true, Alt: alt, Href: href})
+	// flush the cache to a text element, the pointer is already past the image
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, el)
+	return true
+}
 
-	// now get rid of the special chars that are of no use to us
-	newSpecialChars := []indexChar{}
-	count := 0
-	foundClosingSquare := false
-	for i, specialChar := range ctx.specialChars {
-		if count == 1 {
-			newSpecialChars = append(newSpecialChars, ctx.specialChars[i:]...)
-			break
-		}
-		if specialChar.c == ']' {
-			foundClosingSquare = true
-		}
-		if specialChar.c == ')' && foundClosingSquare {
-			count++
-		} else {
-			newSpecialChars = append(newSpecialChars, specialChar)
-		}
+// handleAutolink processes autolinks "<https://example.com>" and "<user@example.com>".
+func (p *OnePassParser) handleAutolink(ctx *variableLineCtx) bool {
+	el, n := parseAutolink(ctx.text[ctx.basePointer:])
+	if el == nil {
+		return false
 	}
-	ctx.specialChars = newSpecialChars
-	// shift the pointer up, it gets incremented by 1 in the loop
-	ctx.basePointer = ctx.lookAheadPointer + 1
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, el)
+
+	// shift the pointer past the autolink
+	ctx.basePointer += n
+	return true
 }
 
-// handleCode processes inline code spans enclosed in backticks "`...`".
-func (p *OnePassParser) handleCode(ctx *variableLineCtx) {
-	if p.err != nil {
-		return
+// handleHTML processes inline raw HTML: a tag, a comment, a processing instruction, a declaration or a CDATA section.
+// It is kept verbatim.
+func (p *OnePassParser) handleHTML(ctx *variableLineCtx) bool {
+	n := parseHTMLTag(ctx.text[ctx.basePointer:])
+	if n == 0 {
+		return false
 	}
-	// let's unshift one char from specialChars
-	ctx.specialChars = ctx.specialChars[1:]
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, &Element{Kind: EKHTMLInline, Text: ctx.text[ctx.basePointer : ctx.basePointer+n]})
+	ctx.basePointer += n
+	return true
+}
 
-	if !ctx.seek('`') {
-		return
+// handleBareURL processes bare "www.", "http://" and "https://" URLs when ExtendedAutolinks is set.
+// Nothing is added after a bare URL on render, so no space is skipped after it.
+func (p *OnePassParser) handleBareURL(ctx *variableLineCtx) bool {
+	n := bareURLAt(ctx.text, ctx.basePointer)
+	if n == 0 {
+		return false
 	}
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, bareLink(ctx.text[ctx.basePointer:ctx.basePointer+n]))
+	ctx.basePointer += n
+	return true
+}
 
-	// we have a code block
-	codeText := p.text[ctx.basePointer+1 : ctx.lookAheadPointer]
-	// flush the cache to a text element
-	p.flushCtxCache()
-	// if there are no more chars we create an ln element
-	if ctx.lookAheadPointer == len(p.text)-1 {
-		p.appendElement(&Element{Kind: EKCodeSpan, LineBreak: true, Text: "`" + codeText + "`"})
-	} else {
-		p.appendElement(&Element{Kind: EKCodeSpan, Text: "`" + codeText + "`"})
+// handleCode processes inline code spans enclosed in matching runs of backticks of any length, such as "`code`".
+// A run of backticks that isn't closed is literal text.
+func (p *OnePassParser) handleCode(ctx *variableLineCtx) bool {
+	code, n := parseCodeSpan(ctx.text[ctx.basePointer:])
+	if n == 0 {
+		run := backtickRun(ctx.text[ctx.basePointer:])
+		ctx.cache = append(ctx.cache, ctx.text[ctx.basePointer:ctx.basePointer+run]...)
+		ctx.basePointer += run
+		return true
 	}
 
-	// let's get rid of the specialChars that are of no use to us
-	newSpecialChars := []indexChar{}
-	count := 0
-	for i, specialChar := range ctx.specialChars {
-		if count == 1 {
-			newSpecialChars = append(newSpecialChars, ctx.specialChars[i:]...)
-			break
-		}
-		if specialChar.c == '`' {
-			count++
-		} else {
-			newSpecialChars = append(newSpecialChars, specialChar)
-		}
-	}
-	ctx.specialChars = newSpecialChars
-	// shift the pointer up, it gets incremented by 1 in the loop
-	ctx.basePointer = ctx.lookAheadPointer
+	// we have a code span, its content is never parsed any further
+	ctx.flushCache()
+	ctx.elements = append(ctx.elements, &Element{Kind: EKCodeSpan, Text: code})
+
+	// shift the pointer past the closing backticks
+	ctx.basePointer += n
+	return true
 }
@@ -1,22 +1,39 @@
 package gomd
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-func normalize(e []*Element) []*Element {
-	out := make([]*Element, 0, len(e))
-	for _, x := range e {
-		if x == nil {
+// parseCase is markdown and the elements both parse routes give for it.
+type parseCase struct {
+	src       string
+	want      []*Element
+	roundTrip bool // the Build of the elements gives src back
+}
+
+// checkBothRoutes parses the markdown of every case with the OnePassParser and with the Lexer and TokenParser, and
+// checks that both give the elements the case wants and, for a round trip, that they build back to the markdown.
+func checkBothRoutes(t *testing.T, cases []parseCase) {
+	t.Helper()
+	for _, tc := range cases {
+		got := NewOnePassParser().Parse(tc.src).Elements
+		if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%q: Parse mismatch (-want +got):\n%s", tc.src, diff)
+		}
+		if diff := cmp.Diff(tc.want, mustParse(t, tc.src), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%q: ParseTokens mismatch (-want +got):\n%s", tc.src, diff)
+		}
+		if !tc.roundTrip {
 			continue
 		}
-		x.Children = normalize(x.Children)
-		out = append(out, x)
+		if out := NewBuilder().Build(got...); out != tc.src {
+			t.Errorf("%q: Build mismatch: got %q", tc.src, out)
+		}
 	}
-	return out
 }
 
 func TestSimpleParseCases(t *testing.T) {
@@ -34,53 +51,80 @@ func TestSimpleParseCases(t *testing.T) {
 		{"h4", "h4.md", []*Element{b.H4("Header test")}},
 		{"h5", "h5.md", []*Element{b.H5("Header test")}},
 		{"h6", "h6.md", []*Element{b.H6("Header test")}},
+		{"setext1", "setext1.md", []*Element{b.Heading(1, HeadingSetext, "Title"), b.NL(), b.Heading(2, HeadingSetext, "Sub")}},
+		{"atx1", "atx1.md", []*Element{b.Heading(2, HeadingATXClosed, "Title"), b.NL(), b.H1("C#")}},
 
 		// TEXT
-		{"textln", "text1.md", []*Element{b.Textln("hi")}},
+		{"textln", "text1.md", []*Element{b.Paragraph(b.Textln("hi"))}},
 
 		// BOLD
-		{"bold1ln", "bold1.md", []*Element{b.Boldln("hi")}},
-		{"bold2", "bold2.md", []*Element{b.Bold("hi"), b.Text(","), b.Boldln("there")}},
+		{"bold1ln", "bold1.md", []*Element{b.Paragraph(b.Boldln("hi"))}},
+		{"bold2", "bold2.md", []*Element{b.Paragraph(b.Bold("hi"), b.Text(","), b.Boldln("there"))}},
 
 		// ITALIC
-		{"italic1ln", "italic1.md", []*Element{b.Italicln("hi")}},
-		{"italic2", "italic2.md", []*Element{b.Italic("hi"), b.Text(","), b.Italicln("there")}},
+		{"italic1ln", "italic1.md", []*Element{b.Paragraph(b.Italicln("hi"))}},
+		{"italic2", "italic2.md", []*Element{b.Paragraph(b.Italic("hi"), b.Text(","), b.Italicln("there"))}},
 
 		// LINK
-		{"link1ln", "link1.md", []*Element{b.Linkln("google", "https://google.com")}},
-		{"link2", "link2.md", []*Element{b.Link("google", "https://google.com"), b.Linkln("amazon", "https://amazon.com")}},
+		{"link1ln", "link1.md", []*Element{b.Paragraph(b.Linkln("google", "https://google.com"))}},
+		{"link2", "link2.md", []*Element{b.Paragraph(b.Link("google", "https://google.com"), b.Linkln("amazon", "https://amazon.com"))}},
 
 		// IMAGE
-		{"img", "img1.md", []*Element{b.Img("alt", "https://google.com/img")}},
-		{"img2", "img2.md", []*Element{b.Img("my-alt", "https://google.com/img"), b.Img("my-alt2", "https://amazon.com/img2")}},
+		{"img", "img1.md", []*Element{b.Paragraph(b.Img("alt", "https://google.com/img"))}},
+		{"img2", "img2.md", []*Element{b.Paragraph(b.Img("my-alt", "https://google.com/img"), b.Img("my-alt2", "https://amazon.com/img2"))}},
 
 		// NL
 		{"nl1", "nl1.md", []*Element{b.NL()}},
-		{"nl2", "nl2.md", []*Element{b.Textln("hi")}},
-		{"nl5", "nl5.md", []*Element{b.Textln("hi"), b.Textln("there")}},
-		{"nl7", "nl7.md", []*Element{b.Textln("hi"), b.NL(), b.Textln("there")}},
+		{"nl2", "nl2.md", []*Element{b.Paragraph(b.Textln("hi"))}},
+		{"nl5", "nl5.md", []*Element{b.Paragraph(b.Textln("hi"), b.Textln("there"))}},
+		{"nl7", "nl7.md", []*Element{b.Paragraph(b.Textln("hi")), b.NL(), b.Paragraph(b.Textln("there"))}},
 
 		// RULE
 		{"rule1", "rule1.md", []*Element{b.Rule()}},
-		{"rule2", "rule2.md", []*Element{b.Textln("hi"), b.Rule(), b.Textln("there")}},
+		{"rule2", "rule2.md", []*Element{b.Paragraph(b.Textln("hi")), b.Rule(), b.Paragraph(b.Textln("there"))}},
+		{"rule3", "rule3.md", []*Element{
+			b.Paragraph(b.Textln("a")), {Kind: EKRule, Fence: "***", LineBreak: true}, b.Paragraph(b.Textln("b")), {Kind: EKRule, Fence: "_ _ _", LineBreak: true}, b.Paragraph(b.Textln("c")),
+		}},
 
 		// Code
-		{"code1ln", "code1.md", []*Element{b.Codeln("hi")}},
-		{"code2", "code2.md", []*Element{b.Code("hi"), b.Text(","), b.Codeln("there")}},
+		{"code1ln", "code1.md", []*Element{b.Paragraph(b.Codeln("hi"))}},
+		{"code2", "code2.md", []*Element{b.Paragraph(b.Code("hi"), b.Text(","), b.Codeln("there"))}},
+
+		// Code fences
+		{"fence1", "fence1.md", []*Element{{Kind: EKCodeBlock, Lang: "go", Fence: "```", Text: `fmt.Println("hi_there *x*")`, LineBreak: true}}},
+		{"fence2", "fence2.md", []*Element{{Kind: EKCodeBlock, Fence: "~~~", Text: "plain", LineBreak: true}}},
+		{"fence3", "fence3.md", []*Element{{Kind: EKCodeBlock, Lang: "md", Fence: "````", Text: "```go\nx := 1\n```", LineBreak: true}}},
+		{"indented1", "indented1.md", []*Element{b.Paragraph(b.Textln("text")), b.NL(), b.IndentedCode("x := *y*\n\nz := _w_"), b.NL(), b.Paragraph(b.Textln("after"))}},
+		{"indented2", "indented2.md", []*Element{b.H1("T"), b.IndentedCode("func_name()")}},
+		{"fence5", "fence5.md", []*Element{{Kind: EKCodeBlock, Lang: "go", Fence: "```", Text: "x := 1\n\ny := 2", LineBreak: true}}},
+
+		// QUOTE
+		{"quote1", "quote1.md", []*Element{b.Quote(b.Paragraph(b.Textln("hi")))}},
+		{"quote2", "quote2.md", []*Element{b.Quote(b.H1("T"), b.UL(paraItem(b.Textln("x"))), b.Quote(b.Paragraph(b.Textln("nested"), b.Textln("lazy"))))}},
+
+		// NESTED INLINE
+		{"nested1", "nested1.md", []*Element{b.Paragraph(b.Strongln(b.Text("bold with "), b.Italic("italic"), b.Text(" and "), b.Link("link", "x")))}},
+		{"nested2", "nested2.md", []*Element{b.Paragraph(b.Emph(b.Text("see "), b.LinkTo("https://x.io", b.Text("the "), b.Bold("docs")), b.Text(" and "), b.Code("code")), b.Textln(" please"))}},
+
+		// ESCAPES
+		{"escape1", "escape1.md", []*Element{b.Paragraph(b.Textln("Not *bold* nor _italic_ or [link](x) or `code`"), b.Textln("# not a heading"), b.Textln("1. not a list"), b.Textln("+ nor this"), b.Textln(`C:\path stays`), b.Boldln("a * b"))}},
 
 		// UL
-		{"ul2", "ul2.md", []*Element{b.UL(b.Textln("hi"))}},
-		{"ul6", "ul6.md", []*Element{b.UL(b.Text("hi "), b.Boldln("there"))}},
-		{"ul7", "ul7.md", []*Element{b.UL(b.Textln("one"), b.Textln("two"), b.Textln("three"))}},
-		{"ul9", "ul9.md", []*Element{b.UL(b.Textln("one"), b.Text("my link: "), b.Linkln("google", "google.com"), b.Textln("three"))}},
-		{"ul10", "ul10.md", []*Element{b.UL(b.Textln("one"), b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool"), b.Textln("three"))}},
+		{"ul2", "ul2.md", []*Element{b.UL(paraItem(b.Textln("hi")))}},
+		{"ul6", "ul6.md", []*Element{b.UL(paraItem(b.Text("hi "), b.Boldln("there")))}},
+		{"ul7", "ul7.md", []*Element{b.UL(paraItem(b.Textln("one")), paraItem(b.Textln("two")), paraItem(b.Textln("three")))}},
+		{"ul9", "ul9.md", []*Element{b.UL(paraItem(b.Textln("one")), paraItem(b.Text("my link: "), b.Linkln("google", "google.com")), paraItem(b.Textln("three")))}},
+		{"ul10", "ul10.md", []*Element{b.UL(paraItem(b.Textln("one")), paraItem(b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool")), paraItem(b.Textln("three")))}},
+		{"ul11", "ul11.md", []*Element{b.ULWith('*', paraItem(b.Textln("star")), paraItem(b.Textln("items"))), b.ULWith('+', paraItem(b.Textln("plus")))}},
 
 		// OL
-		{"ol2", "ol2.md", []*Element{b.OL(b.Textln("hi"))}},
-		{"ol6", "ol6.md", []*Element{b.OL(b.Text("hi "), b.Boldln("there"))}},
-		{"ol7", "ol7.md", []*Element{b.OL(b.Textln("one"), b.Textln("two"), b.Textln("three"))}},
-		{"ol9", "ol9.md", []*Element{b.OL(b.Textln("one"), b.Text("my link: "), b.Linkln("google", "google.com"), b.Textln("three"))}},
-		{"ol10", "ol10.md", []*Element{b.OL(b.Textln("one"), b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool"), b.Textln("three"))}},
+		{"ol2", "ol2.md", []*Element{b.OL(paraItem(b.Textln("hi")))}},
+		{"ol6", "ol6.md", []*Element{b.OL(paraItem(b.Text("hi "), b.Boldln("there")))}},
+		{"ol7", "ol7.md", []*Element{b.OL(paraItem(b.Textln("one")), paraItem(b.Textln("two")), paraItem(b.Textln("three")))}},
+		{"ol9", "ol9.md", []*Element{b.OL(paraItem(b.Textln("one")), paraItem(b.Text("my link: "), b.Linkln("google", "google.com")), paraItem(b.Textln("three")))}},
+		{"ol10", "ol10.md", []*Element{b.OL(paraItem(b.Textln("one")), paraItem(b.Text("my link: "), b.Link("google", "google.com"), b.Boldln("So Cool")), paraItem(b.Textln("three")))}},
+		{"ol11", "ol11.md", []*Element{b.OL(paraItem(b.Textln("item 1")), paraItem(b.Textln("item 2")), paraItem(b.Textln("item 3")), paraItem(b.Textln("item 4")), paraItem(b.Textln("item 5")), paraItem(b.Textln("item 6")), paraItem(b.Textln("item 7")), paraItem(b.Textln("item 8")), paraItem(b.Textln("item 9")), paraItem(b.Textln("item 10")), paraItem(b.Textln("item 11")))}},
+		{"ol12", "ol12.md", []*Element{b.OLFrom(7, ')', paraItem(b.Textln("seven")), paraItem(b.Textln("eight")), paraItem(b.Textln("nine")), paraItem(b.Textln("ten")))}},
 	}
 
 	opts := []cmp.Option{
@@ -96,9 +140,451 @@ func TestSimpleParseCases(t *testing.T) {
 				t.Fatal(err)
 			}
 			got := p.Parse(string(md))
-			if diff := cmp.Diff(&Document{Elements: normalize(tc.want)}, got, opts...); diff != "" {
+			if diff := cmp.Diff(&Document{Elements: tc.want}, got, opts...); diff != "" {
 				t.Fatalf("Build mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestParseReferenceLinks(t *testing.T) {
+	p := NewOnePassParser()
+	got := p.Parse("[Docs][d] and [D][]\n![d]\n[nope] stays\n\n[d]: https://d.io 'Title'\n")
+
+	def := &Element{Kind: EKLinkDef, Ref: "d", Href: "https://d.io", Title: "Title", LineBreak: true}
+	want := &Document{
+		Elements: []*Element{
+			{Kind: EKParagraph, Children: []*Element{
+				{Kind: EKLink, Text: "Docs", Href: "https://d.io", Title: "Title", Ref: "d", LinkStyle: LinkFull},
+				{Kind: EKText, Text: " and "},
+				{Kind: EKLink, Text: "D", Href: "https://d.io", Title: "Title", Ref: "D", LinkStyle: LinkCollapsed, LineBreak: true},
+				{Kind: EKImage, Alt: "d", Href: "https://d.io", Title: "Title", Ref: "d", LinkStyle: LinkShortcut, LineBreak: true},
+				{Kind: EKText, Text: "[nope] stays", LineBreak: true},
+			}},
+			{Kind: EKNewLine, LineBreak: true},
+			def,
+		},
+		Definitions: map[string]*Element{"d": def},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("Parse mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseAutolinks(t *testing.T) {
+	src := "<https://a.io/x_y> and www.b.io/path_(1). or (https://c.io/q?a=1)\nnotwww.d.io nor http://e\n"
+	want := []*Element{
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKLink, Text: "https://a.io/x_y", Href: "https://a.io/x_y", LinkStyle: LinkAuto},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKLink, Text: "www.b.io/path_(1)", Href: "http://www.b.io/path_(1)", LinkStyle: LinkBare},
+			{Kind: EKText, Text: ". or ("},
+			{Kind: EKLink, Text: "https://c.io/q?a=1", Href: "https://c.io/q?a=1", LinkStyle: LinkBare},
+			{Kind: EKText, Text: ")", LineBreak: true},
+			{Kind: EKText, Text: "notwww.d.io nor http://e", LineBreak: true},
+		}},
+	}
+
+	p := NewOnePassParser()
+	p.ExtendedAutolinks = true
+	got := p.Parse(src)
+	if diff := cmp.Diff(want, got.Elements, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("Parse mismatch (-want +got):\n%s", diff)
+	}
+	if out := NewBuilder().Build(got.Elements...); out != src {
+		t.Fatalf("Build mismatch: got %q, want %q", out, src)
+	}
+
+	// without the option, bare URLs stay text
+	got = NewOnePassParser().Parse("see www.b.io\n")
+	if diff := cmp.Diff([]*Element{{Kind: EKParagraph, Children: []*Element{{Kind: EKText, Text: "see www.b.io", LineBreak: true}}}}, got.Elements); diff != "" {
+		t.Fatalf("Parse mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLiteralText(t *testing.T) {
+	src := "## Use `go` **now**\n**a \\* b** and _c\\_d_ `e\\f` [g\\]](h\\(i\\)) ![j\\*](k)\n"
+	want := []*Element{
+		{Kind: EKHeading, Level: 2, LineBreak: true, Children: []*Element{
+			{Kind: EKText, Text: "Use "},
+			{Kind: EKCodeSpan, Text: "go"},
+			{Kind: EKText, Text: " "},
+			{Kind: EKBold, Delim: '*', Text: "now"},
+		}},
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKBold, Delim: '*', Text: "a * b"},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKItalic, Delim: '_', Text: "c_d"},
+			{Kind: EKText, Text: " "},
+			{Kind: EKCodeSpan, Text: "e\\f"},
+			{Kind: EKText, Text: " "},
+			{Kind: EKLink, Text: "g]", Href: "h(i)"},
+			{Kind: EKImage, Alt: "j*", Href: "k", LineBreak: true},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestLegacyText(t *testing.T) {
+	b := NewBuilder()
+	cases := []struct {
+		el   *Element
+		want string
+	}{
+		{b.Text("a*b"), "a*b"},
+		{b.Bold("a*b"), "**a\\*b**"},
+		{b.Italic("hi"), "_hi_"},
+		{b.Code("x"), "`x`"},
+		{b.Link("[x]", "y"), "\\[x\\]"},
+		{b.Rule(), "\n---\n"},
+		{b.Raw("**raw**"), "**raw**"},
+		{&Element{Kind: EKHeading, Level: 1, Children: []*Element{b.Text("a "), b.Bold("b")}}, "a **b**"},
+	}
+	for _, tc := range cases {
+		if got := LegacyText(tc.el); got != tc.want {
+			t.Errorf("LegacyText(%v) = %q, want %q", tc.el.Kind, got, tc.want)
+		}
+	}
+}
+
+func TestParseListItems(t *testing.T) {
+	src := "- a\n  continued\n- b\n\n1. one\n\n   more\n\n2. two\n"
+	want := []*Element{
+		{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: []*Element{
+			{Kind: EKListItem, Children: []*Element{
+				{Kind: EKParagraph, Children: []*Element{
+					{Kind: EKText, Text: "a", LineBreak: true},
+					{Kind: EKText, Text: "continued", LineBreak: true},
+				}},
+			}},
+			{Kind: EKListItem, Children: []*Element{
+				{Kind: EKParagraph, Children: []*Element{{Kind: EKText, Text: "b", LineBreak: true}}},
+			}},
+		}},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKList, ListKind: ListOrdered, Start: 1, Delim: '.', Loose: true, Children: []*Element{
+			{Kind: EKListItem, Children: []*Element{
+				{Kind: EKParagraph, Children: []*Element{{Kind: EKText, Text: "one", LineBreak: true}}},
+				{Kind: EKNewLine, LineBreak: true},
+				{Kind: EKParagraph, Children: []*Element{{Kind: EKText, Text: "more", LineBreak: true}}},
+			}},
+			{Kind: EKListItem, Children: []*Element{
+				{Kind: EKParagraph, Children: []*Element{{Kind: EKText, Text: "two", LineBreak: true}}},
+			}},
+		}},
+	}
+
+	got := NewOnePassParser().Parse(src)
+	if diff := cmp.Diff(want, got.Elements, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("Parse mismatch (-want +got):\n%s", diff)
+	}
+	if out := NewBuilder().Build(got.Elements...); out != src {
+		t.Fatalf("Build mismatch: got %q, want %q", out, src)
+	}
+}
+
+// the OnePassParser nests a list indented past its level in a list of its own, which renders with the indentation
+func TestParseIndentedList(t *testing.T) {
+	src := "  - x\n  - y\n"
+	want := []*Element{bullets(bullets(paraItem(line("x")), paraItem(line("y"))))}
+
+	got := NewOnePassParser().Parse(src)
+	if diff := cmp.Diff(want, got.Elements, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("Parse mismatch (-want +got):\n%s", diff)
+	}
+	if out := NewBuilder().Build(got.Elements...); out != src {
+		t.Fatalf("Build mismatch: got %q, want %q", out, src)
+	}
+}
+
+// a list of another kind after an indented list closes the lists the indented list is nested in
+func TestParseListAfterIndentedList(t *testing.T) {
+	src := "  - x\n2) y\n* z\n"
+	got := NewOnePassParser().Parse(src)
+	if out := NewBuilder().Build(got.Elements...); out != src {
+		t.Fatalf("Build mismatch: got %q, want %q", out, src)
+	}
+}
+
+func TestParseNestedListItems(t *testing.T) {
+	src := "- a\n  - b\n  - c\n- d\n1. one\n   - x\n"
+	want := []*Element{
+		bullets(
+			item(para(line("a")), bullets(paraItem(line("b")), paraItem(line("c")))),
+			paraItem(line("d")),
+		),
+		{Kind: EKList, ListKind: ListOrdered, Start: 1, Delim: '.', Children: []*Element{
+			item(para(line("one")), bullets(paraItem(line("x")))),
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, true}})
+}
+
+func TestParseHardBreaks(t *testing.T) {
+	src := "a  \nb\\\nc \n\nd\\\n\ne\\\\\nf\n"
+	want := []*Element{
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKText, Text: "a"},
+			{Kind: EKHardBreak, Fence: "  ", LineBreak: true},
+			{Kind: EKText, Text: "b"},
+			{Kind: EKHardBreak, Fence: "\\", LineBreak: true},
+			{Kind: EKText, Text: "c", LineBreak: true}, // a soft break, the space is dropped
+		}},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKText, Text: "d\\", LineBreak: true}, // the paragraph ends, so the backslash is literal
+		}},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKText, Text: "e\\", LineBreak: true}, // an escaped backslash
+			{Kind: EKText, Text: "f", LineBreak: true},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestParseEmphasis(t *testing.T) {
+	src := "*a* __b__ ***c*** snake_case_name 2 * 3\n**x *y* z** _p*q_ *r **s***\n"
+	want := []*Element{
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKItalic, Delim: '*', Text: "a"},
+			text(" "),
+			{Kind: EKBold, Delim: '_', Text: "b"},
+			text(" "),
+			{Kind: EKItalic, Delim: '*', Children: []*Element{{Kind: EKBold, Delim: '*', Text: "c"}}},
+			{Kind: EKText, Text: " snake_case_name 2 * 3", LineBreak: true},
+			{Kind: EKBold, Delim: '*', Children: []*Element{
+				text("x "), {Kind: EKItalic, Delim: '*', Text: "y"}, text(" z"),
+			}},
+			text(" "),
+			{Kind: EKItalic, Delim: '_', Text: "p*q"},
+			text(" "),
+			{Kind: EKItalic, Delim: '*', LineBreak: true, Children: []*Element{
+				text("r "), {Kind: EKBold, Delim: '*', Text: "s"},
+			}},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, true}})
+}
+
+func TestParseCodeSpans(t *testing.T) {
+	src := "``a`b`` and ` `` ` and `C:\\dir\\` and `` `x` `` and ``` unclosed\n"
+	want := []*Element{
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKCodeSpan, Text: "a`b"},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKCodeSpan, Text: "``"},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKCodeSpan, Text: `C:\dir\`},
+			{Kind: EKText, Text: " and "},
+			{Kind: EKCodeSpan, Text: "`x`"},
+			{Kind: EKText, Text: " and ``` unclosed", LineBreak: true},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, true}})
+}
+
+func TestParseHTML(t *testing.T) {
+	md, err := Read("testdata/html1.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(md)
+	html := func(s string) *Element { return &Element{Kind: EKHTMLInline, Text: s} }
+	want := []*Element{
+		{Kind: EKHTMLBlock, Text: "<details>\n<summary>More</summary>", LineBreak: true},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKParagraph, Children: []*Element{
+			text("Text with "), html("<kbd>"), text("Ctrl"), html("</kbd>"), text(" and "),
+			html(`<img width="20" src="a_b.png">`), text(" inline"), {Kind: EKHTMLInline, Text: "<br>", LineBreak: true},
+		}},
+		{Kind: EKHTMLBlock, Text: "<!-- a comment\nover lines -->", LineBreak: true},
+		{Kind: EKHTMLBlock, Text: "</details>", LineBreak: true},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKHTMLBlock, Text: "<span class=\"x\">\n</span>", LineBreak: true},
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKParagraph, Children: []*Element{
+			text("a "), html("<!-- c -->"), {Kind: EKText, Text: " b", LineBreak: true},
+			{Kind: EKHTMLInline, Text: "<em>", LineBreak: true}, // a lone tag can't interrupt a paragraph
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestParseEntities(t *testing.T) {
+	src := "AT&T &amp; &copy; &#169; &#xA9; &#0; \\&amp; &nope; [x](/a?b=1&amp;c=2) `&amp;`\n"
+	want := []*Element{
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKText, Text: "AT&T & © © © \uFFFD &amp; &nope; "},
+			{Kind: EKLink, Text: "x", Href: "/a?b=1&c=2"},
+			{Kind: EKCodeSpan, Text: "&amp;", LineBreak: true},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+
+	// only the '&' of a reference is escaped, so the text reads back as it is
+	out := NewBuilder().Build(NewOnePassParser().Parse(src).Elements...)
+	if want := "AT&T & © © © \uFFFD \\&amp; &nope; [x](/a?b=1&c=2) `&amp;`\n"; out != want {
+		t.Fatalf("Build mismatch: got %q, want %q", out, want)
+	}
+	if diff := cmp.Diff(want, NewOnePassParser().Parse(out).Elements, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("Parse of Build mismatch (-want +got):\n%s", diff)
+	}
+	if out, want := NewBuilder().Build(NewBuilder().Linkln("&copy;", "/a?b&copy;")), "[\\&copy;](/a?b\\&copy;)\n"; out != want {
+		t.Fatalf("Build mismatch: got %q, want %q", out, want)
+	}
+}
+
+func TestParseTables(t *testing.T) {
+	src := "a | b\n:-|-:\nx \\| y | `c\\|d`\nonly\n| 1 | 2 | 3 |\n\nnot | a table\n--- | --- | ---\n"
+	cell := func(inline ...*Element) *Element { return &Element{Kind: EKTableCell, Children: inline} }
+	row := func(cells ...*Element) *Element { return &Element{Kind: EKTableRow, Children: cells} }
+	want := []*Element{
+		{Kind: EKTable, Align: []Alignment{AlignLeft, AlignRight}, LineBreak: true, Children: []*Element{
+			row(cell(text("a")), cell(text("b"))),
+			row(cell(text("x | y")), cell(&Element{Kind: EKCodeSpan, Text: "c|d"})),
+			row(cell(text("only")), cell()),       // missing cells are empty
+			row(cell(text("1")), cell(text("2"))), // extra cells are dropped
+		}},
+		{Kind: EKNewLine, LineBreak: true},
+		// the delimiter row needs as many cells as the header
+		{Kind: EKParagraph, Children: []*Element{
+			{Kind: EKText, Text: "not | a table", LineBreak: true},
+			{Kind: EKText, Text: "--- | --- | ---", LineBreak: true},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestParseTasks(t *testing.T) {
+	src := "- [ ] a\n- [x] b\n  more\n- [X] c\n- [ ]d\n- [y] e\n"
+	want := []*Element{
+		{Kind: EKList, ListKind: ListUnordered, Bullet: '-', Children: []*Element{
+			{Kind: EKListItem, Task: true, Children: []*Element{para(line("a"))}},
+			{Kind: EKListItem, Task: true, Checked: true, Children: []*Element{para(line("b"), line("more"))}},
+			{Kind: EKListItem, Task: true, Checked: true, Children: []*Element{para(line("c"))}},
+			// a box needs a space after it, and only holds a space or an x
+			{Kind: EKListItem, Children: []*Element{para(line("[ ]d"))}},
+			{Kind: EKListItem, Children: []*Element{para(line("[y] e"))}},
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestDocumentTasks(t *testing.T) {
+	md, err := Read("testdata/task1.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewOnePassParser().Parse(string(md))
+
+	var texts []string
+	for _, task := range doc.Tasks() {
+		texts = append(texts, TaskText(task))
+	}
+	want := []string{"Tag the release", "Publish the notes", "on the blog", "on the list", "Bump the version in go.mod", "Run go test ./..."}
+	if diff := cmp.Diff(want, texts); diff != "" {
+		t.Fatalf("Tasks mismatch (-want +got):\n%s", diff)
+	}
+
+	if checked, ok := doc.TaskChecked("Tag the  release"); !ok || !checked {
+		t.Fatalf("TaskChecked = %v, %v, want true, true", checked, ok)
+	}
+	if _, ok := doc.TaskChecked("not a task"); ok {
+		t.Fatal("TaskChecked found an item that isn't a task")
+	}
+	if !doc.CheckTask("on the blog", true) || !doc.ToggleTask("Tag the release") || doc.ToggleTask("missing") {
+		t.Fatal("CheckTask or ToggleTask didn't find their task")
+	}
+
+	out := NewBuilder().Build(doc.Elements...)
+	ticked := strings.NewReplacer("- [x] Tag", "- [ ] Tag", "- [ ] on the blog", "- [x] on the blog").Replace(string(md))
+	if out != ticked {
+		t.Fatalf("Build mismatch: got %q, want %q", out, ticked)
+	}
+}
+
+func TestParseStrike(t *testing.T) {
+	src := "~~a~~ ~b~ x~~y~~z ~~~c~~~ ~d~~ ~~e *f*~~ \\~~g~~ ~~ h~~\n"
+	strike := func(fence string, children ...*Element) *Element {
+		return &Element{Kind: EKStrike, Fence: fence, Children: children}
+	}
+	want := []*Element{para(
+		&Element{Kind: EKStrike, Fence: "~~", Text: "a"}, text(" "),
+		&Element{Kind: EKStrike, Fence: "~", Text: "b"}, text(" x"),
+		&Element{Kind: EKStrike, Fence: "~~", Text: "y"},
+		// runs of three tildes and runs of different lengths don't match
+		text("z ~~~c~~~ ~d~~ "),
+		strike("~~", text("e "), &Element{Kind: EKItalic, Delim: '*', Text: "f"}),
+		// an escaped tilde leaves a run of one that can't match two, and a run followed by a space can't open
+		&Element{Kind: EKText, Text: " ~~g~~ ~~ h~~", LineBreak: true},
+	)}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+}
+
+func TestParseFootnotes(t *testing.T) {
+	src := "a[^1] b[^x] [^none] [c][^1]\n\n[^1]: one\n[^x]: two\nthree\n\n    four\n"
+	ref := func(label string) *Element { return &Element{Kind: EKFootnoteRef, Ref: label} }
+	want := []*Element{
+		// a label without a definition is literal text, and a footnote label never refers to a link
+		para(text("a"), ref("1"), text(" b"), ref("x"), text(" [^none] [c]"), &Element{Kind: EKFootnoteRef, Ref: "1", LineBreak: true}),
+		{Kind: EKNewLine, LineBreak: true},
+		{Kind: EKFootnoteDef, Ref: "1", LineBreak: true, Children: []*Element{para(&Element{Kind: EKText, Text: "one", LineBreak: true})}},
+		{Kind: EKFootnoteDef, Ref: "x", LineBreak: true, Children: []*Element{
+			para(&Element{Kind: EKText, Text: "two", LineBreak: true}, &Element{Kind: EKText, Text: "three", LineBreak: true}),
+			{Kind: EKNewLine, LineBreak: true},
+			para(&Element{Kind: EKText, Text: "four", LineBreak: true}),
+		}},
+	}
+
+	checkBothRoutes(t, []parseCase{{src, want, false}})
+
+	doc := NewOnePassParser().Parse(src)
+	if doc.Definitions["^x"] != doc.Elements[3] {
+		t.Fatalf("Definitions[^x] = %v, want the parsed definition", doc.Definitions["^x"])
+	}
+}
+
+// an ordered marker without a space after it doesn't open a list item, its line is paragraph text
+func TestParseOrderedMarkerWithoutSpace(t *testing.T) {
+	checkBothRoutes(t, []parseCase{
+		{"1.a\nb", []*Element{para(line("1.a"), line("b"))}, false},
+		{"3.14 is pi\nsecond line", []*Element{para(line("3.14 is pi"), line("second line"))}, false},
+	})
+}
+
+// quotes that hold nothing but another quote are stripped in one pass, lazy lines still go to the innermost paragraph
+func TestParseNestedQuotes(t *testing.T) {
+	checkBothRoutes(t, []parseCase{
+		{"> > > a\n> > b\nc\n", []*Element{quote(quote(quote(para(line("a"), line("b"), line("c")))))}, false},
+		{"> > a\n>\n> b\n", []*Element{quote(quote(para(line("a"))), &Element{Kind: EKNewLine, LineBreak: true}, para(line("b")))}, false},
+		{strings.Repeat(">", 5) + " deep\n", []*Element{nestQuote([]*Element{para(line("deep"))}, 4)}, false},
+	})
+}
+
+// an item holding a heading, a quote or a fence is parsed into its blocks on both routes, and its marker may be
+// indented by up to 3 spaces
+func TestParseListItemBlocks(t *testing.T) {
+	checkBothRoutes(t, []parseCase{
+		{"- # h\n- > q\n- x\n", []*Element{bullets(
+			item(&Element{Kind: EKHeading, Level: 1, Text: "h", LineBreak: true}),
+			item(quote(para(line("q")))),
+			item(para(line("x"))),
+		)}, true},
+		{"- > q\nlazy\n- ```\n  code\n  ```\n", []*Element{bullets(
+			item(quote(para(line("q"), line("lazy")))),
+			item(&Element{Kind: EKCodeBlock, Fence: "```", Text: "code", LineBreak: true}),
+		)}, false},
+		{" - x\n - y\npara\n", []*Element{bullets(item(para(line("x"))), item(para(line("y"), line("para"))))}, false},
+	})
+}
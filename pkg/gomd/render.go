@@ -3,17 +3,27 @@ package gomd
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 // listFrame represents a frame in the rendering context for lists.
 type listFrame struct {
-	kind  ListType
-	index int
+	kind   ListType
+	index  int
+	bullet byte
+	start  int
+	delim  byte
+	loose  bool
 }
 
 // renderCtx holds the state for rendering a Markdown document.
 type renderCtx struct {
 	frames      []listFrame
+	bullet      byte
+	emphasis    byte
+	html        HTMLPolicy
+	quoteDepth  int
+	notes       *footnotes
 	lineBuffer  *strings.Builder
 	startOfLine bool
 }
@@ -24,14 +34,53 @@ func (ctx *renderCtx) lineBreak() {
 	ctx.startOfLine = true
 }
 
-// pushFrame adds a new list frame to the context.
-func (ctx *renderCtx) pushFrame(kind ListType) {
-	ctx.frames = append(ctx.frames, listFrame{kind: kind})
+// pushFrame adds a new list frame to the context. Unordered lists use the context's bullet, or their own, or '-',
+// ordered lists are numbered from their Start, 0 included, and use '.' unless the list says ')'.
+func (ctx *renderCtx) pushFrame(list *Element) {
+	f := listFrame{kind: list.ListKind, bullet: list.Bullet, start: list.Start, delim: list.Delim, loose: list.Loose}
+	if isBullet(ctx.bullet) {
+		f.bullet = ctx.bullet
+	}
+	if !isBullet(f.bullet) {
+		f.bullet = '-'
+	}
+	if f.delim != ')' {
+		f.delim = '.'
+	}
+	ctx.frames = append(ctx.frames, f)
 }
 
 // popFrame removes the last list frame from the context.
 func (ctx *renderCtx) popFrame() { ctx.frames = ctx.frames[:len(ctx.frames)-1] }
 
+// pushQuote enters a nested blockquote.
+func (ctx *renderCtx) pushQuote() { ctx.quoteDepth++ }
+
+// popQuote leaves the innermost blockquote.
+func (ctx *renderCtx) popQuote() { ctx.quoteDepth-- }
+
+// writeQuoted writes the rendered lines in s to buf, prefixing every line with one "> " per level of quote depth.
+// Blank lines get the markers without the trailing space. Outside of quotes, the blank lines s starts with are
+// dropped where they would make more than one blank line in a row after buf.
+func (ctx *renderCtx) writeQuoted(buf *strings.Builder, s string) {
+	if ctx.quoteDepth == 0 {
+		buf.WriteString(trimBlankLines(buf.String(), s))
+		return
+	}
+
+	prefix := strings.Repeat("> ", ctx.quoteDepth)
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if line == "\n" {
+			buf.WriteString(strings.TrimRight(prefix, " ") + line)
+		} else {
+			buf.WriteString(prefix + line)
+		}
+	}
+}
+
 // computeIndent calculates the indentation for the current list frame based on its parent frames.
 func (ctx *renderCtx) computeIndent() (*listFrame, string) {
 	var indent string
@@ -55,10 +104,11 @@ func (ctx *renderCtx) computeIndent() (*listFrame, string) {
 			indent = "\n" + indent
 		}
 
-		// if the parent is an OL and we're a child OL starting at 0, inherit index to continue numbering
+		// if the parent is an OL and we're a child OL starting at 0, inherit its numbering to continue it
 		pf := ctx.frames[len(ctx.frames)-2]
-		if pf.kind == ListOrdered && f.index == 0 {
+		if pf.kind == ListOrdered && f.index == 0 && f.start == 1 {
 			f.index = pf.index
+			f.start = pf.start
 		}
 	}
 	return f, indent
@@ -75,15 +125,47 @@ func (ctx *renderCtx) listPrefix() string {
 	switch f.kind {
 	case ListUnordered:
 		f.index++
-		return indent + "- "
+		return indent + string(f.bullet) + " "
 	case ListOrdered:
 		f.index++
-		return fmt.Sprintf("%s%d. ", indent, f.index)
+		return fmt.Sprintf("%s%d%c ", indent, f.start+f.index-1, f.delim)
 	default:
 		return ""
 	}
 }
 
+// listLine returns a line of inline elements with the prefix of the current list frame. In a list, text that would
+// be read as the box of a task item is escaped.
+func (ctx *renderCtx) listLine(line string) string {
+	if len(ctx.frames) == 0 {
+		return line
+	}
+	return ctx.listPrefix() + escapeTaskBox(line)
+}
+
+// heading returns the markdown for a heading in its recorded style.
+func (ctx *renderCtx) heading(el *Element) string {
+	hashes := strings.Repeat("#", el.Level)
+	text := escapeText(el.Text, false)
+	if len(el.Children) > 0 {
+		text = ctx.inlineChildren(el.Children)
+	}
+	switch {
+	case el.Style == HeadingSetext && (el.Level == 1 || el.Level == 2):
+		underline := "="
+		if el.Level == 2 {
+			underline = "-"
+		}
+		return text + "\n" + strings.Repeat(underline, max(3, utf8.RuneCountInString(text)))
+	case text == "":
+		return hashes
+	case el.Style == HeadingATXClosed:
+		return hashes + " " + text + " " + hashes
+	default:
+		return hashes + " " + text
+	}
+}
+
 // renderText is used to parse an Element and recurse through the associated Children Elements.
 // It converts Element pointers into compatible markdown text and handles nesting.
 func (ctx *renderCtx) renderText(b *Builder, buf *strings.Builder, el *Element) {
@@ -93,76 +175,362 @@ func (ctx *renderCtx) renderText(b *Builder, buf *strings.Builder, el *Element)
 
 	switch el.Kind {
 	case EKHeading:
-		ctx.lineBuffer.WriteString(strings.Repeat("#", el.Level) + " " + el.Text)
+		ctx.lineBuffer.WriteString(ctx.heading(el))
 	case EKList:
-		ctx.pushFrame(el.ListKind)
+		ctx.pushFrame(el)
 		defer ctx.popFrame()
+	case EKListItem:
+		ctx.listItem(b, buf, el)
+		return
 	case EKCodeBlock:
-		ctx.lineBuffer.WriteString(fmt.Sprintf("```%s\n%s\n\n```", el.Lang, el.Text))
+		if el.Indented {
+			ctx.lineBuffer.WriteString(indentedCode(el.Text))
+			break
+		}
+		fence := el.Fence
+		if fence == "" {
+			fence = pickFence(el.Text)
+		}
+		if el.Text == "" {
+			ctx.lineBuffer.WriteString(fmt.Sprintf("%s%s\n%s", fence, el.Lang, fence))
+		} else {
+			ctx.lineBuffer.WriteString(fmt.Sprintf("%s%s\n%s\n%s", fence, el.Lang, el.Text, fence))
+		}
 	case EKQuote:
-		ctx.lineBuffer.WriteString("> ")
-	case EKLink:
-		ctx.lineBuffer.WriteString(fmt.Sprintf("[%s](%s)", el.Text, el.Href))
-		if !el.LineBreak {
-			ctx.lineBuffer.WriteString(" ")
+		ctx.pushQuote()
+		defer ctx.popQuote()
+	case EKParagraph:
+		// the lines of a paragraph are its Children
+	case EKTable:
+		ctx.lineBuffer.WriteString(ctx.table(el))
+	case EKText:
+		ctx.lineBuffer.WriteString(escapeText(el.Text, ctx.lineBuffer.Len() == 0))
+	case EKLinkDef:
+		ctx.lineBuffer.WriteString(linkDefinition(el))
+	case EKBold, EKItalic, EKStrike, EKCodeSpan, EKImage, EKFootnoteRef:
+		ctx.lineBuffer.WriteString(ctx.inlineMarkdown(el))
+	case EKFootnoteDef:
+		// Build writes the footnotes at the end of the document
+		return
+	case EKRule:
+		ctx.lineBuffer.WriteString("\n" + ruleMarker(el) + "\n")
+	case EKHardBreak:
+		ctx.lineBuffer.WriteString(hardBreakMarker(el))
+	case EKHTMLBlock:
+		if ctx.html == HTMLStrip {
+			return
 		}
-	case EKImage:
-		ctx.lineBuffer.WriteString(fmt.Sprintf("![%s](%s)", el.Alt, el.Href))
+		ctx.lineBuffer.WriteString(ctx.inlineMarkdown(el))
+	case EKHTMLInline:
+		ctx.lineBuffer.WriteString(ctx.inlineMarkdown(el))
+	case EKLink:
+		ctx.lineBuffer.WriteString(ctx.inlineMarkdown(el))
 	default:
+		// EKRaw is pre-formatted markdown, written as it is
 		ctx.lineBuffer.WriteString(el.Text)
 	}
 
 	if el.LineBreak {
+		// trailing spaces would turn a soft line break into a hard one, which only EKHardBreak writes
+		if el.Kind != EKHardBreak && el.Kind != EKRaw && el.Kind != EKCodeBlock && el.Kind != EKHTMLBlock {
+			line := strings.TrimRight(ctx.lineBuffer.String(), " ")
+			ctx.lineBuffer.Reset()
+			ctx.lineBuffer.WriteString(line)
+		}
 		if ctx.lineBuffer.String() != "" {
 			ctx.lineBreak()
-			buf.WriteString(ctx.listPrefix() + ctx.lineBuffer.String())
+			ctx.writeQuoted(buf, ctx.listLine(ctx.lineBuffer.String()))
 		} else {
 			ctx.lineBreak()
-			buf.WriteString(ctx.lineBuffer.String())
+			ctx.writeQuoted(buf, ctx.lineBuffer.String())
 		}
 		ctx.lineBuffer.Reset()
 	}
 
+	// the Children of inline spans, headings and tables are their content, already written above, and those of
+	// a footnote reference are its footnote, written at the end of the document
+	if hasInlineChildren(el.Kind) || el.Kind == EKTable || el.Kind == EKFootnoteRef {
+		return
+	}
+
 	b.cleanLastElement(el.Children)
-	for _, child := range el.Children {
+	for i, child := range el.Children {
+		if i > 0 {
+			ctx.separate(b, buf, el.Children[i-1], child)
+		}
 		ctx.startOfLine = true
 		ctx.renderText(b, buf, child)
 	}
 }
 
-// collapseRuns returns s with any run of '\n' longer than max reduced to max.
-func (ctx *renderCtx) collapseRuns(s string, max int) string {
-	if max < 1 {
-		return s
+// separate writes a blank line between the sibling elements prev and next where the markdown needs one:
+// a paragraph would otherwise run on into the text or paragraph after it, or turn an indented code block after it
+// into more of its text, and it would be read as a lazy continuation line of text before it.
+// Whatever would be read as a lazy continuation line of a quote or a list is kept out of it the same way.
+// An HTML block running to a blank line would take in whatever follows it, and a table would take in the text
+// after it as another row. A link is kept apart from an inline element after it by a space, as in inlineChildren.
+func (ctx *renderCtx) separate(b *Builder, buf *strings.Builder, prev, next *Element) {
+	gap := false
+	switch {
+	case prev == nil || next == nil:
+	case linkSpace(prev, next):
+		ctx.lineBuffer.WriteString(" ")
+	case prev.Kind == EKHTMLBlock:
+		gap = next.Kind != EKNewLine && ctx.html != HTMLStrip && htmlBlockStart(prev.Text, false) >= 6
+	case prev.Kind == EKTable:
+		gap = next.Kind == EKParagraph || isInline(next.Kind)
+	case prev.Kind == EKParagraph:
+		gap = next.Kind == EKParagraph || next.Kind == EKTable || isInline(next.Kind) || (next.Kind == EKCodeBlock && next.Indented)
+	case prev.Kind == EKQuote, prev.Kind == EKList:
+		gap = ctx.continuesLazily(next)
+	case next.Kind == EKParagraph, next.Kind == EKTable:
+		gap = isInline(prev.Kind)
+	}
+	if gap {
+		ctx.renderText(b, buf, &Element{Kind: EKNewLine, LineBreak: true})
 	}
-	if !strings.Contains(s, "\n\n") {
-		return s
+}
+
+// continuesLazily reports whether the first line of el, written right after a quote or a list, would be read as a
+// lazy continuation line of the paragraph they end with: text, or a block that can't interrupt a paragraph.
+func (ctx *renderCtx) continuesLazily(el *Element) bool {
+	switch el.Kind {
+	case EKParagraph, EKTable:
+		return true
+	case EKCodeBlock:
+		return el.Indented
+	case EKHeading:
+		return el.Style == HeadingSetext && (el.Level == 1 || el.Level == 2)
+	case EKHTMLBlock:
+		return ctx.html == HTMLEscape || (ctx.html == HTMLPass && htmlBlockStart(el.Text, true) == 0)
 	}
+	return isInline(el.Kind)
+}
 
-	var b strings.Builder
-	b.Grow(len(s))
+// listItem writes a list item holding blocks. Its Children are rendered on their own, then the first line gets the
+// list marker and the other lines are indented to line up with the text after it.
+// The items of a loose list are separated by a blank line. A task item gets its box after the marker.
+func (ctx *renderCtx) listItem(b *Builder, buf *strings.Builder, el *Element) {
+	// inline elements left without a line break end their line before the item
+	if ctx.lineBuffer.Len() > 0 {
+		ctx.lineBreak()
+		ctx.writeQuoted(buf, ctx.listLine(ctx.lineBuffer.String()))
+		ctx.lineBuffer.Reset()
+	}
 
-	run := 0
-	for i := 0; i < len(s); i++ {
-		if s[i] == '\n' {
-			if run < max {
-				b.WriteByte('\n')
-			}
-			run++
-		} else {
-			run = 0
-			b.WriteByte(s[i])
+	var out strings.Builder
+	if len(ctx.frames) > 0 {
+		if f := ctx.frames[len(ctx.frames)-1]; f.loose && f.index > 0 {
+			out.WriteString("\n")
+		}
+	}
+	prefix := ctx.listPrefix()
+	indent := strings.Repeat(" ", len(strings.TrimLeft(prefix, "\n")))
+	box := taskBox(el)
+	for i, line := range strings.Split(ctx.renderBlocks(b, el.Children), "\n") {
+		switch {
+		case i == 0 && line == "" && box == "":
+			// an empty item keeps the space after its marker, without it the marker reads back as text
+			out.WriteString(prefix)
+		case i == 0 && box == "":
+			out.WriteString(prefix + escapeTaskBox(line))
+		case i == 0:
+			out.WriteString(prefix + box + line)
+		case line != "":
+			out.WriteString(indent + line)
 		}
+		out.WriteString("\n")
 	}
+	ctx.writeQuoted(buf, out.String())
+}
+
+// renderBlocks renders the elements the way Build renders a document, for containers that prefix each of their lines.
+func (ctx *renderCtx) renderBlocks(b *Builder, elements []*Element) string {
+	sub := &renderCtx{frames: []listFrame{}, bullet: ctx.bullet, emphasis: ctx.emphasis, html: ctx.html, notes: ctx.notes, lineBuffer: &strings.Builder{}}
+	var buf strings.Builder
+	b.cleanLastElement(elements)
+	for i, el := range elements {
+		if i > 0 {
+			sub.separate(b, &buf, elements[i-1], el)
+		}
+		sub.startOfLine = true
+		sub.renderText(b, &buf, el)
+	}
+	return strings.Trim(buf.String(), "\n")
+}
+
+// hasInlineChildren reports whether elements of the kind hold their inline content as Children.
+func hasInlineChildren(kind ElementKind) bool {
+	return kind == EKBold || kind == EKItalic || kind == EKStrike || kind == EKLink || kind == EKHeading
+}
+
+// inlineMarkdown returns the markdown for an inline element, nesting its Children if it has any.
+// Text holds the literal content, the delimiters and escapes are added here.
+func (ctx *renderCtx) inlineMarkdown(el *Element) string {
+	switch el.Kind {
+	case EKBold, EKItalic:
+		delim := ctx.emphasisDelim(el)
+		if len(el.Children) > 0 {
+			return delim + ctx.inlineChildren(el.Children) + delim
+		}
+		return delim + escapeSpanText(el.Text, delim[:1]) + delim
+	case EKStrike:
+		text := escapeSpanText(el.Text, "~")
+		if len(el.Children) > 0 {
+			text = ctx.inlineChildren(el.Children)
+		}
+		// there is nothing to strike, and the tildes alone would open a code fence
+		if text == "" {
+			return ""
+		}
+		fence := strikeFence(el)
+		return fence + text + fence
+	case EKCodeSpan:
+		return codeSpan(el.Text)
+	case EKLink:
+		switch el.LinkStyle {
+		case LinkAuto:
+			return "<" + el.Text + ">"
+		case LinkBare:
+			return el.Text
+		}
+		if len(el.Children) > 0 {
+			return "[" + ctx.inlineChildren(el.Children) + "]" + linkTarget(el)
+		}
+		return "[" + escapeLinkText(el.Text) + "]" + linkTarget(el)
+	case EKImage:
+		return "![" + escapeLinkText(el.Alt) + "]" + linkTarget(el)
+	case EKFootnoteRef:
+		return "[^" + ctx.footnoteLabel(el) + "]"
+	case EKText:
+		return escapeText(el.Text, false)
+	case EKHTMLBlock, EKHTMLInline:
+		switch ctx.html {
+		case HTMLEscape:
+			return escapeHTML(el.Text)
+		case HTMLStrip:
+			return ""
+		}
+	}
+	return el.Text
+}
+
+// emphasisDelim returns the delimiter of bold or italic text: the context's, or the element's, or "**" for bold
+// and "_" for italic.
+func (ctx *renderCtx) emphasisDelim(el *Element) string {
+	char := el.Delim
+	if ctx.emphasis == '*' || ctx.emphasis == '_' {
+		char = ctx.emphasis
+	}
+	switch {
+	case char != '*' && char != '_' && el.Kind == EKBold:
+		return "**"
+	case char != '*' && char != '_':
+		return "_"
+	case el.Kind == EKBold:
+		return strings.Repeat(string(char), 2)
+	}
+	return string(char)
+}
+
+// strikeFence returns the tildes around struck text as they were written, "~" or "~~", or "~~".
+func strikeFence(el *Element) string {
+	if el.Fence == "~" {
+		return el.Fence
+	}
+	return "~~"
+}
 
+// ruleMarker returns the marker of a rule as it was written, or "---".
+func ruleMarker(el *Element) string {
+	if el.Fence == "" {
+		return "---"
+	}
+	return el.Fence
+}
+
+// hardBreakMarker returns the marker of a hard break as it was written, two or more spaces or a backslash, or a backslash.
+func hardBreakMarker(el *Element) string {
+	if el.Fence == "\\" || (len(el.Fence) >= 2 && strings.Trim(el.Fence, " ") == "") {
+		return el.Fence
+	}
+	return "\\"
+}
+
+// linkTarget returns what follows the text of a link or image in its style: "(href)", "[ref]", "[]" or nothing.
+// Autolinks and bare URLs have no text of their own and are written by inlineMarkdown.
+func linkTarget(el *Element) string {
+	switch el.LinkStyle {
+	case LinkFull:
+		return "[" + el.Ref + "]"
+	case LinkCollapsed:
+		return "[]"
+	case LinkShortcut:
+		return ""
+	default:
+		return "(" + escapeURL(el.Href) + linkTitle(el.Title) + ")"
+	}
+}
+
+// isRefStyle reports whether links of the style refer to a link reference definition.
+func isRefStyle(style LinkStyle) bool {
+	return style == LinkFull || style == LinkCollapsed || style == LinkShortcut
+}
+
+// pendingDefinitions returns the definitions for the reference links and images among the elements that
+// aren't defined by a link definition element of their own, in order of first use.
+func pendingDefinitions(elements []*Element) []*Element {
+	defined := map[string]bool{}
+	Walk(elements, func(el *Element) {
+		if el.Kind == EKLinkDef {
+			defined[normalizeLabel(el.Ref)] = true
+		}
+	})
+
+	var defs []*Element
+	Walk(elements, func(el *Element) {
+		if (el.Kind != EKLink && el.Kind != EKImage) || !isRefStyle(el.LinkStyle) || el.Href == "" {
+			return
+		}
+		label := normalizeLabel(el.Ref)
+		if defined[label] {
+			return
+		}
+		defined[label] = true
+		defs = append(defs, &Element{Kind: EKLinkDef, Ref: el.Ref, Href: el.Href, Title: el.Title, LineBreak: true})
+	})
+	return defs
+}
+
+// inlineChildren joins the markdown of nested inline elements. As on a line, a link is followed by a space where
+// linkSpace says so.
+func (ctx *renderCtx) inlineChildren(children []*Element) string {
+	var b strings.Builder
+	for i, child := range children {
+		b.WriteString(ctx.inlineMarkdown(child))
+		if i+1 < len(children) && linkSpace(child, children[i+1]) {
+			b.WriteString(" ")
+		}
+	}
 	return b.String()
 }
 
+// trimBlankLines drops the newlines s starts with beyond those that make one blank line after out, which ends in
+// its own newlines. Only the separators between blocks are trimmed: the runs of newlines inside s, such as the blank
+// lines of a code block, are kept as they are.
+func trimBlankLines(out, s string) string {
+	tail := len(out) - len(strings.TrimRight(out, "\n"))
+	lead := len(s) - len(strings.TrimLeft(s, "\n"))
+	if drop := tail + lead - 2; drop > 0 {
+		return s[min(drop, lead):]
+	}
+	return s
+}
+
 // cleanRender processes the rendered string to ensure it meets Markdown formatting standards.
 func (ctx *renderCtx) cleanRender(s string) string {
 	s = strings.ReplaceAll(s, "\r\n", "\n")
 	s = strings.TrimLeft(s, "\n")
-	s = ctx.collapseRuns(s, 2)
 	s = strings.TrimRight(s, " \t")
 	if !strings.HasSuffix(s, "\n") {
 		s += "\n"
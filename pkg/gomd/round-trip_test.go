@@ -25,6 +25,8 @@ func TestRoundTrip(t *testing.T) {
 		{"h4", "h4.md"},
 		{"h5", "h5.md"},
 		{"h6", "h6.md"},
+		{"setext1", "setext1.md"},
+		{"atx1", "atx1.md"},
 
 		// TEXT
 		{"text1", "text1.md"},
@@ -47,6 +49,42 @@ func TestRoundTrip(t *testing.T) {
 		{"link2", "link2.md"},
 		{"link2ln", "link2.md"},
 
+		// NESTED INLINE
+		{"nested1", "nested1.md"},
+		{"nested2", "nested2.md"},
+		{"nested3", "nested3.md"},
+		{"emph1", "emph1.md"},
+
+		// REFERENCE LINKS
+		{"refs1", "refs1.md"},
+		{"refs2", "refs2.md"},
+		{"refs3", "refs3.md"},
+
+		// AUTOLINKS
+		{"autolink1", "autolink1.md"},
+		{"autolink2", "autolink2.md"},
+
+		// ESCAPES
+		{"escape1", "escape1.md"},
+		{"escape2", "escape2.md"},
+		{"escape3", "escape3.md"},
+
+		// LITERAL
+		{"literal1", "literal1.md"},
+
+		// STRIKE
+		{"strike1", "strike1.md"},
+
+		// FOOTNOTES
+		{"footnote1", "footnote1.md"},
+
+		// HARD BREAKS
+		{"break1", "break1.md"},
+		{"break2", "break2.md"},
+
+		// PARAGRAPHS
+		{"para1", "para1.md"},
+
 		// IMAGE
 		{"img", "img1.md"},
 		{"img2", "img2.md"},
@@ -65,12 +103,34 @@ func TestRoundTrip(t *testing.T) {
 		// Rule
 		{"rule1", "rule1.md"},
 		{"rule2", "rule2.md"},
+		{"rule3", "rule3.md"},
 
 		// Code
 		{"code1", "code1.md"},
 		{"code1ln", "code1.md"},
 		{"code2", "code2.md"},
 		{"code2ln", "code2.md"},
+		{"code3", "code3.md"},
+
+		// Code fences
+		{"fence1", "fence1.md"},
+		{"fence2", "fence2.md"},
+		{"fence3", "fence3.md"},
+		{"fence4", "fence4.md"},
+		{"fence6", "fence6.md"},
+		{"indented1", "indented1.md"},
+
+		// HTML
+		{"html1", "html1.md"},
+
+		// Table
+		{"table1", "table1.md"},
+
+		// Quote
+		{"quote1", "quote1.md"},
+		{"quote3", "quote3.md"},
+		{"quote4", "quote4.md"},
+		{"quote5", "quote5.md"},
 
 		// UL
 		{"ul1", "nl1.md"},
@@ -83,6 +143,13 @@ func TestRoundTrip(t *testing.T) {
 		{"ul8", "ul8.md"},
 		{"ul9", "ul9.md"},
 		{"ul10", "ul10.md"},
+		{"ul11", "ul11.md"},
+		{"ul12", "ul12.md"},
+		{"list1", "list1.md"},
+		{"list2", "list2.md"},
+		{"list3", "list3.md"},
+		{"list4", "list4.md"},
+		{"task1", "task1.md"},
 
 		// OL
 		{"ol1", "nl1.md"},
@@ -95,6 +162,9 @@ func TestRoundTrip(t *testing.T) {
 		{"ol8", "ol8.md"},
 		{"ol9", "ol9.md"},
 		{"ol10", "ol10.md"},
+		{"ol11", "ol11.md"},
+		{"ol12", "ol12.md"},
+		{"ol13", "ol13.md"},
 	}
 
 	opts := []cmp.Option{
@@ -0,0 +1,350 @@
+package gomd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var updateSpec = flag.Bool("update-spec", false, "record the CommonMark spec examples that pass in "+specPassingPath)
+
+// specPath holds the examples of the CommonMark spec 0.31.2, the spec.json published at specSource as it is.
+const (
+	specPath        = "testdata/commonmark/spec.json"
+	specPassingPath = "testdata/commonmark/passing.txt"
+	specSource      = "https://spec.commonmark.org/0.31.2/spec.json"
+)
+
+// specExample is an example of the CommonMark spec, in the format of the spec.json of the reference implementations.
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// specRoute is a parse route run against the spec examples.
+type specRoute struct {
+	name  string
+	parse func(md string) ([]*Element, error)
+}
+
+var specRoutes = []specRoute{
+	{"onepass", func(md string) ([]*Element, error) {
+		return NewOnePassParser().Parse(md).Elements, nil
+	}},
+	{"tokens", func(md string) ([]*Element, error) {
+		toks, err := NewLexer().Tokenize(strings.NewReader(md))
+		if err != nil {
+			return nil, err
+		}
+		doc, err := NewTokenParser().ParseTokens(toks)
+		if err != nil {
+			return nil, err
+		}
+		return doc.Elements, nil
+	}},
+}
+
+// loadSpec reads the spec examples. Every example must have the number the spec gives it, which is how the passing
+// file lists them.
+func loadSpec(t *testing.T) []specExample {
+	t.Helper()
+	b, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", specPath, err)
+	}
+	var examples []specExample
+	if err := json.Unmarshal(b, &examples); err != nil {
+		t.Fatalf("decode %s: %v", specPath, err)
+	}
+	for i, ex := range examples {
+		if ex.Example == 0 {
+			t.Fatalf("%s: the example at %d has no number, it must be the spec.json from %s as it is", specPath, i, specSource)
+		}
+	}
+	return examples
+}
+
+// specID names an example run through a route by its number in the spec, as listed in the passing file.
+func specID(route string, ex specExample) string {
+	return route + ": #" + strconv.Itoa(ex.Example)
+}
+
+// loadPassing reads the examples known to pass, one specID per line.
+func loadPassing(t *testing.T) map[string]bool {
+	t.Helper()
+	passing := map[string]bool{}
+	b, err := os.ReadFile(specPassingPath)
+	if os.IsNotExist(err) {
+		return passing
+	}
+	if err != nil {
+		t.Fatalf("read %s: %v", specPassingPath, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			passing[line] = true
+		}
+	}
+	return passing
+}
+
+// runSpecExample parses the markdown of the example and renders it to HTML. A panic in the parser fails the example.
+func runSpecExample(route specRoute, ex specExample) (got string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	els, err := route.parse(ex.Markdown)
+	if err != nil {
+		return "", err
+	}
+	return renderSpecHTML(els), nil
+}
+
+// TestCommonMarkSpec runs the CommonMark spec examples through both parse routes and reports how many pass in each
+// section, run it with -v to see the report. The examples known to pass are listed in testdata/commonmark/passing.txt
+// by their number in the spec: one of them failing is a regression. Run with -update-spec to record newly passing
+// examples.
+func TestCommonMarkSpec(t *testing.T) {
+	examples := loadSpec(t)
+	known := loadPassing(t)
+	var passing []string
+
+	for _, route := range specRoutes {
+		t.Run(route.name, func(t *testing.T) {
+			var sections []string
+			pass, total := map[string]int{}, map[string]int{}
+			fresh := 0
+			for _, ex := range examples {
+				if total[ex.Section] == 0 {
+					sections = append(sections, ex.Section)
+				}
+				total[ex.Section]++
+
+				id := specID(route.name, ex)
+				got, err := runSpecExample(route, ex)
+				if err == nil && got == ex.HTML {
+					pass[ex.Section]++
+					passing = append(passing, id)
+					if !known[id] {
+						fresh++
+					}
+					continue
+				}
+				if known[id] {
+					t.Errorf("%s regressed\nmarkdown: %q\nwant: %q\ngot:  %q\nerr:  %v", id, ex.Markdown, ex.HTML, got, err)
+				}
+			}
+
+			var report strings.Builder
+			passed := 0
+			for _, section := range sections {
+				fmt.Fprintf(&report, "\n  %-42s %3d/%-3d", section, pass[section], total[section])
+				passed += pass[section]
+			}
+			fmt.Fprintf(&report, "\n  %-42s %3d/%-3d", "total", passed, len(examples))
+			t.Logf("CommonMark spec examples passing:%s", report.String())
+			if fresh > 0 && !*updateSpec {
+				t.Logf("%d examples pass that %s doesn't list yet, run with -update-spec to record them", fresh, specPassingPath)
+			}
+		})
+	}
+
+	if *updateSpec {
+		if err := os.WriteFile(specPassingPath, []byte(strings.Join(passing, "\n")+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", specPassingPath, err)
+		}
+	}
+}
+
+// renderSpecHTML renders the elements to HTML the way the CommonMark reference implementation does,
+// so that the output can be compared with the spec examples.
+func renderSpecHTML(els []*Element) string {
+	var w specHTML
+	w.blocks(els, false)
+	return w.String()
+}
+
+// specHTML writes the HTML of an element tree.
+type specHTML struct {
+	strings.Builder
+}
+
+// cr starts a new line, unless the output is empty or already at the start of one.
+func (w *specHTML) cr() {
+	if s := w.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		w.WriteString("\n")
+	}
+}
+
+// blocks writes block elements. The paragraphs of a tight list item are written without their <p> tags.
+func (w *specHTML) blocks(els []*Element, tight bool) {
+	for _, el := range els {
+		if el == nil {
+			continue
+		}
+		switch el.Kind {
+		case EKParagraph:
+			if tight {
+				w.inline(el.Children)
+				continue
+			}
+			w.cr()
+			w.WriteString("<p>")
+			w.inline(el.Children)
+			w.WriteString("</p>")
+			w.cr()
+		case EKHeading:
+			w.cr()
+			fmt.Fprintf(w, "<h%d>", el.Level)
+			w.span(el)
+			fmt.Fprintf(w, "</h%d>", el.Level)
+			w.cr()
+		case EKCodeBlock:
+			w.cr()
+			w.WriteString("<pre><code")
+			if el.Lang != "" {
+				w.WriteString(` class="language-` + specEscape(unescapeText(el.Lang)) + `"`)
+			}
+			w.WriteString(">" + specEscape(el.Text))
+			if el.Text != "" {
+				w.WriteString("\n")
+			}
+			w.WriteString("</code></pre>")
+			w.cr()
+		case EKQuote:
+			w.cr()
+			w.WriteString("<blockquote>\n")
+			w.blocks(el.Children, false)
+			w.cr()
+			w.WriteString("</blockquote>")
+			w.cr()
+		case EKList:
+			w.list(el)
+		case EKRule:
+			w.cr()
+			w.WriteString("<hr />")
+			w.cr()
+		case EKHTMLBlock:
+			w.cr()
+			w.WriteString(el.Text)
+			w.cr()
+		case EKNewLine, EKLinkDef:
+		default:
+			w.inline([]*Element{el})
+		}
+	}
+}
+
+// list writes a list and its items, the items of a list that isn't loose are tight.
+func (w *specHTML) list(el *Element) {
+	tag := "ul"
+	w.cr()
+	if el.ListKind == ListOrdered {
+		tag = "ol"
+		if el.Start != 1 {
+			fmt.Fprintf(w, `<ol start="%d">`, el.Start)
+		} else {
+			w.WriteString("<ol>")
+		}
+	} else {
+		w.WriteString("<ul>")
+	}
+	w.WriteString("\n")
+	for _, item := range listItems(el.Children) {
+		w.WriteString("<li>")
+		w.blocks(paragraphs(item.Children), !el.Loose)
+		w.WriteString("</li>\n")
+	}
+	w.WriteString("</" + tag + ">")
+	w.cr()
+}
+
+// inline writes inline elements, a line break between them is a newline.
+func (w *specHTML) inline(els []*Element) {
+	for i, el := range els {
+		switch el.Kind {
+		case EKText:
+			w.WriteString(specEscape(el.Text))
+		case EKBold:
+			w.WriteString("<strong>")
+			w.span(el)
+			w.WriteString("</strong>")
+		case EKItalic:
+			w.WriteString("<em>")
+			w.span(el)
+			w.WriteString("</em>")
+		case EKStrike:
+			w.WriteString("<del>")
+			w.span(el)
+			w.WriteString("</del>")
+		case EKCodeSpan:
+			w.WriteString("<code>" + specEscape(el.Text) + "</code>")
+		case EKLink:
+			w.WriteString(`<a href="` + specURL(el.Href) + `"` + specTitle(el.Title) + ">")
+			w.span(el)
+			w.WriteString("</a>")
+		case EKImage:
+			w.WriteString(`<img src="` + specURL(el.Href) + `" alt="` + specEscape(el.Alt) + `"` + specTitle(el.Title) + " />")
+		case EKHardBreak:
+			w.WriteString("<br />")
+		default:
+			// EKRaw, EKHTMLInline
+			w.WriteString(el.Text)
+		}
+		if el.LineBreak && i < len(els)-1 {
+			w.WriteString("\n")
+		}
+	}
+}
+
+// span writes the content of an inline element: its Children, or its literal Text.
+func (w *specHTML) span(el *Element) {
+	if len(el.Children) > 0 {
+		w.inline(el.Children)
+		return
+	}
+	w.WriteString(specEscape(el.Text))
+}
+
+var specEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// specEscape escapes text for HTML.
+func specEscape(s string) string { return specEscaper.Replace(s) }
+
+// specTitle returns the title attribute of a link or image, or nothing.
+func specTitle(title string) string {
+	if title == "" {
+		return ""
+	}
+	return ` title="` + specEscape(title) + `"`
+}
+
+// specURL percent-encodes the characters of a URL that aren't safe in it, keeping those already encoded, and
+// escapes it for HTML.
+func specURL(u string) string {
+	var b strings.Builder
+	for i := 0; i < len(u); i++ {
+		c := u[i]
+		switch {
+		case c == '%' && i+2 < len(u) && isHexDigit(u[i+1]) && isHexDigit(u[i+2]),
+			isASCIILetter(c) || isASCIIDigit(c) || strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return specEscape(b.String())
+}
+
+// isHexDigit checks if c is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return isASCIIDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,212 @@
+package gomd
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// tableCells returns the byte ranges of the cells of a table row, trimmed of spaces. A leading and a trailing pipe
+// are optional, and an escaped pipe "\|" doesn't end a cell.
+func tableCells(line string) [][2]int {
+	lo, hi := 0, len(line)
+	for lo < hi && (line[lo] == ' ' || line[lo] == '\t') {
+		lo++
+	}
+	for hi > lo && (line[hi-1] == ' ' || line[hi-1] == '\t') {
+		hi--
+	}
+	if lo < hi && line[lo] == '|' {
+		lo++
+	}
+
+	var cells [][2]int
+	start := lo
+	for i := lo; i < hi; i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, trimCell(line, start, i))
+			start = i + 1
+		}
+	}
+	// a trailing pipe closes the last cell rather than opening an empty one
+	if start < hi || len(cells) == 0 {
+		cells = append(cells, trimCell(line, start, hi))
+	}
+	return cells
+}
+
+// trimCell returns the range [lo, hi) of the line without the spaces around it.
+func trimCell(line string, lo, hi int) [2]int {
+	for lo < hi && (line[lo] == ' ' || line[lo] == '\t') {
+		lo++
+	}
+	for hi > lo && (line[hi-1] == ' ' || line[hi-1] == '\t') {
+		hi--
+	}
+	return [2]int{lo, hi}
+}
+
+// hasPipe checks if the line holds a pipe that isn't escaped.
+func hasPipe(line string) bool {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapePipes replaces the escaped pipes "\|" of a cell with the pipes they stand for, before its inline content
+// is parsed, so a pipe can be written inside code spans too.
+func unescapePipes(s string) string {
+	if !strings.Contains(s, "\\|") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] != '|' {
+				b.WriteByte(s[i])
+			}
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseTableDelims parses the delimiter row of a table, such as "| :-- | :-: | --: |", into the alignment of each
+// column. Each cell is a run of dashes with optional colons at its ends, and the row needs a pipe.
+func parseTableDelims(line string) ([]Alignment, bool) {
+	indent, rest := leadingIndent(line)
+	if indent > 3 || !hasPipe(rest) {
+		return nil, false
+	}
+	var align []Alignment
+	for _, c := range tableCells(line) {
+		cell := line[c[0]:c[1]]
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		dashes := strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil, false
+		}
+		switch {
+		case left && right:
+			align = append(align, AlignCenter)
+		case left:
+			align = append(align, AlignLeft)
+		case right:
+			align = append(align, AlignRight)
+		default:
+			align = append(align, AlignNone)
+		}
+	}
+	return align, true
+}
+
+// tableStart checks if the header line and the line after it open a table: the header holds a pipe, and the next
+// line is a delimiter row with as many cells. It returns the alignment of each column.
+func tableStart(header, delims string) ([]Alignment, bool) {
+	indent, rest := leadingIndent(header)
+	if indent > 3 || !hasPipe(rest) {
+		return nil, false
+	}
+	align, ok := parseTableDelims(delims)
+	if !ok || len(align) != len(tableCells(header)) {
+		return nil, false
+	}
+	return align, true
+}
+
+// continuesTable checks if the line is another row of the table above it, rather than blank or the start of another block.
+func continuesTable(line string) bool {
+	return !startsBlock(line)
+}
+
+// tableRow builds a row of a table with cols columns from the parsed inline content of its cells.
+// Missing cells are left empty, and cells past the last column are dropped.
+func tableRow(cells [][]*Element, cols int) *Element {
+	row := &Element{Kind: EKTableRow}
+	for i := 0; i < cols; i++ {
+		cell := &Element{Kind: EKTableCell}
+		if i < len(cells) {
+			cell.Children = cells[i]
+		}
+		row.Children = append(row.Children, cell)
+	}
+	return row
+}
+
+// table returns the markdown for a table: its header row, the delimiter row and its body rows, with the cells of each
+// column padded to the same width and aligned as the column is. Pipes inside the cells are escaped.
+func (ctx *renderCtx) table(el *Element) string {
+	rows := el.Children
+	if len(rows) == 0 {
+		return ""
+	}
+	cols := len(rows[0].Children)
+	cells := make([][]string, len(rows))
+	widths := make([]int, cols)
+	for i, row := range rows {
+		cells[i] = make([]string, cols)
+		for j := 0; j < cols && j < len(row.Children); j++ {
+			text := strings.ReplaceAll(ctx.inlineChildren(row.Children[j].Children), "|", "\\|")
+			cells[i][j] = text
+			widths[j] = max(widths[j], utf8.RuneCountInString(text))
+		}
+	}
+	align := make([]Alignment, cols)
+	copy(align, el.Align)
+	for j := range widths {
+		widths[j] = max(widths[j], 3)
+	}
+
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range cells {
+		lines = append(lines, tableLine(row, func(j int, cell string) string { return padCell(cell, widths[j], align[j]) }))
+		if i == 0 {
+			lines = append(lines, tableLine(row, func(j int, _ string) string { return delimCell(widths[j], align[j]) }))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableLine joins the cells of a row, written by cell, between pipes.
+func tableLine(row []string, cell func(j int, text string) string) string {
+	var b strings.Builder
+	b.WriteString("|")
+	for j, text := range row {
+		b.WriteString(" " + cell(j, text) + " |")
+	}
+	return b.String()
+}
+
+// padCell pads the text of a cell with spaces to the width of its column, on the side its alignment calls for.
+func padCell(text string, width int, align Alignment) string {
+	pad := width - utf8.RuneCountInString(text)
+	switch align {
+	case AlignRight:
+		return strings.Repeat(" ", pad) + text
+	case AlignCenter:
+		return strings.Repeat(" ", pad/2) + text + strings.Repeat(" ", pad-pad/2)
+	}
+	return text + strings.Repeat(" ", pad)
+}
+
+// delimCell returns the cell of the delimiter row for a column of the width and alignment, such as ":---:".
+func delimCell(width int, align Alignment) string {
+	switch align {
+	case AlignLeft:
+		return ":" + strings.Repeat("-", width-1)
+	case AlignRight:
+		return strings.Repeat("-", width-1) + ":"
+	case AlignCenter:
+		return ":" + strings.Repeat("-", width-2) + ":"
+	}
+	return strings.Repeat("-", width)
+}
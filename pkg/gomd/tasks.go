@@ -0,0 +1,121 @@
+package gomd
+
+import "strings"
+
+// parseTaskBox checks if the text of a list item opens with a task box, "[ ]", "[x]" or "[X]" followed by a space
+// or a tab. It returns whether the box is checked and the text after it.
+func parseTaskBox(text string) (checked bool, rest string, ok bool) {
+	if len(text) < 4 || text[0] != '[' || text[2] != ']' || (text[3] != ' ' && text[3] != '\t') {
+		return false, text, false
+	}
+	switch text[1] {
+	case ' ':
+		return false, text[4:], true
+	case 'x', 'X':
+		return true, text[4:], true
+	}
+	return false, text, false
+}
+
+// taskBox returns the markdown for the task box of a list item, or nothing if the item isn't a task.
+func taskBox(item *Element) string {
+	switch {
+	case !item.Task:
+		return ""
+	case item.Checked:
+		return "[x] "
+	}
+	return "[ ] "
+}
+
+// escapeTaskBox escapes the '[' of a line of a list item that isn't a task but would be read as one.
+func escapeTaskBox(line string) string {
+	if _, _, ok := parseTaskBox(line); ok {
+		return "\\" + line
+	}
+	return line
+}
+
+// Tasks returns the task list items of the document, in order.
+func (d *Document) Tasks() []*Element {
+	var tasks []*Element
+	Walk(d.Elements, func(el *Element) {
+		if el.Kind == EKListItem && el.Task {
+			tasks = append(tasks, el)
+		}
+	})
+	return tasks
+}
+
+// TaskChecked reports whether the first task whose text is text is checked, and whether the document has such a task.
+// The text is matched as TaskText returns it.
+func (d *Document) TaskChecked(text string) (checked bool, ok bool) {
+	task := d.findTask(text)
+	if task == nil {
+		return false, false
+	}
+	return task.Checked, true
+}
+
+// CheckTask sets the check state of the first task whose text is text, and reports whether the document has such a task.
+// Build the elements of the document to write it back with the box ticked or cleared.
+func (d *Document) CheckTask(text string, checked bool) bool {
+	task := d.findTask(text)
+	if task == nil {
+		return false
+	}
+	task.Checked = checked
+	return true
+}
+
+// ToggleTask flips the check state of the first task whose text is text, and reports whether the document has such a task.
+func (d *Document) ToggleTask(text string) bool {
+	task := d.findTask(text)
+	if task == nil {
+		return false
+	}
+	task.Checked = !task.Checked
+	return true
+}
+
+// findTask returns the first task whose text is text, or nil.
+func (d *Document) findTask(text string) *Element {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, task := range d.Tasks() {
+		if TaskText(task) == text {
+			return task
+		}
+	}
+	return nil
+}
+
+// TaskText returns the plain text of the first paragraph of a list item, without its markup and with runs of
+// whitespace collapsed, such as "Tag the release" for "- [ ] Tag the **release**".
+func TaskText(item *Element) string {
+	for _, block := range paragraphs(item.Children) {
+		if block.Kind == EKParagraph {
+			var b strings.Builder
+			writePlainText(&b, block.Children)
+			return strings.Join(strings.Fields(b.String()), " ")
+		}
+	}
+	return ""
+}
+
+// writePlainText writes the literal text of inline elements, a line break between them is a space.
+func writePlainText(b *strings.Builder, inline []*Element) {
+	for _, el := range inline {
+		switch {
+		case len(el.Children) > 0:
+			writePlainText(b, el.Children)
+		case el.Kind == EKImage:
+			b.WriteString(el.Alt)
+		case el.Kind == EKText || el.Kind == EKCodeSpan || hasInlineChildren(el.Kind):
+			// bold, italic and link text without Children hold it as their Text
+			b.WriteString(el.Text)
+		}
+		if el.LineBreak {
+			b.WriteString(" ")
+		}
+	}
+}
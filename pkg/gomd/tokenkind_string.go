@@ -21,12 +21,16 @@ func _() {
 	_ = x[THash-10]
 	_ = x[TNewline-11]
 	_ = x[TOLMarker-12]
-	_ = x[TEOF-13]
+	_ = x[TQuoteMarker-13]
+	_ = x[TLAngle-14]
+	_ = x[TRAngle-15]
+	_ = x[TTilde-16]
+	_ = x[TEOF-17]
 }
 
-const _TokenKind_name = "TTextTStarTUnderscoreTLBracketTRBracketTLParenTRParenTBacktickTBangTDashTHashTNewlineTOLMarkerTEOF"
+const _TokenKind_name = "TTextTStarTUnderscoreTLBracketTRBracketTLParenTRParenTBacktickTBangTDashTHashTNewlineTOLMarkerTQuoteMarkerTLAngleTRAngleTTildeTEOF"
 
-var _TokenKind_index = [...]uint8{0, 5, 10, 21, 30, 39, 46, 53, 62, 67, 72, 77, 85, 94, 98}
+var _TokenKind_index = [...]uint8{0, 5, 10, 21, 30, 39, 46, 53, 62, 67, 72, 77, 85, 94, 106, 113, 120, 126, 130}
 
 func (i TokenKind) String() string {
 	if i < 0 || i >= TokenKind(len(_TokenKind_index)-1) {
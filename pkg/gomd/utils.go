@@ -1,5 +1,7 @@
 package gomd
 
+import "slices"
+
 // Walk traverses the elements and applies the visit function to each element.
 func Walk(elems []*Element, visit func(*Element)) {
 	for _, el := range elems {
@@ -11,6 +13,21 @@ func Walk(elems []*Element, visit func(*Element)) {
 	}
 }
 
+// walkBlocks is Walk for the block structure of the elements: it only descends into lists, list items, quotes and
+// footnote definitions, never into inline content.
+func walkBlocks(elems []*Element, visit func(*Element)) {
+	for _, el := range elems {
+		if el == nil {
+			continue
+		}
+		visit(el)
+		switch el.Kind {
+		case EKList, EKListItem, EKQuote, EKFootnoteDef:
+			walkBlocks(el.Children, visit)
+		}
+	}
+}
+
 // DeepCopy returns a deep copy of the element tree.
 func DeepCopy(e *Element) *Element {
 	if e == nil {
@@ -18,6 +35,7 @@ func DeepCopy(e *Element) *Element {
 	}
 	// copy the element struct
 	cp := *e
+	cp.Align = slices.Clone(e.Align)
 
 	// copy children recursively
 	if len(e.Children) > 0 {
@@ -46,6 +64,142 @@ func inlineWrap(wrap, s string) string {
 	return wrap + s + wrap
 }
 
+// isPlainText checks if parsed inline content is empty or a single run of text without any markup.
+func isPlainText(children []*Element) bool {
+	return len(children) == 0 || (len(children) == 1 && children[0].Kind == EKText)
+}
+
+// spanElement builds an inline element of the given kind around its parsed content.
+// Plain text becomes the literal Text of the element, while nested markup becomes the Children.
+func spanElement(kind ElementKind, children []*Element) *Element {
+	if isPlainText(children) {
+		return &Element{Kind: kind, Text: plainText(children)}
+	}
+	return &Element{Kind: kind, Children: children}
+}
+
+// plainText returns the literal text of parsed inline content that isPlainText.
+func plainText(children []*Element) string {
+	if len(children) == 0 {
+		return ""
+	}
+	return children[0].Text
+}
+
+// LegacyText returns the Text of an element the way it was stored before Text held only the literal content:
+// bold, italic and code spans wrapped in their delimiters and escaped, link text escaped, rules as "\n---\n"
+// and headings with their inline markup. It eases the migration of code that reads Text as markdown.
+// Code that writes markdown into Text should use Builder.Raw instead.
+func LegacyText(el *Element) string {
+	switch el.Kind {
+	case EKBold, EKItalic, EKCodeSpan:
+		return (&renderCtx{}).inlineMarkdown(el)
+	case EKLink:
+		if len(el.Children) > 0 {
+			return (&renderCtx{}).inlineChildren(el.Children)
+		}
+		return escapeLinkText(el.Text)
+	case EKHeading:
+		if len(el.Children) > 0 {
+			return (&renderCtx{}).inlineChildren(el.Children)
+		}
+	case EKRule:
+		return "\n" + ruleMarker(el) + "\n"
+	}
+	return el.Text
+}
+
+// listItems groups the flat Children of a list into EKListItem elements, one per line of inline elements.
+// A hard break doesn't end the item, its next line belongs to it too.
+// A nested list belongs to the item before it, and children that already are items are kept as they are. A list
+// with no item before it, such as the list the OnePassParser nests an indented list in, is kept as it is too, so that
+// it renders with its indentation.
+func listItems(children []*Element) []*Element {
+	var items []*Element
+	var open *Element // the item of the current line, until its line break
+	for _, child := range children {
+		switch {
+		case child == nil:
+		case child.Kind == EKListItem:
+			items = append(items, child)
+			open = nil
+		case child.Kind == EKList && open == nil && len(items) == 0:
+			items = append(items, child)
+		case child.Kind == EKList && open == nil:
+			last := items[len(items)-1]
+			last.Children = append(last.Children, child)
+		default:
+			if open == nil {
+				open = &Element{Kind: EKListItem}
+				items = append(items, open)
+			}
+			open.Children = append(open.Children, child)
+			if (child.LineBreak && child.Kind != EKHardBreak) || child.Kind == EKList {
+				open = nil
+			}
+		}
+	}
+	return items
+}
+
+// groupListItems turns the flat Children of every list in the elements into EKListItem elements.
+func groupListItems(elements []*Element) {
+	walkBlocks(elements, func(el *Element) {
+		if el.Kind == EKList {
+			el.Children = listItems(el.Children)
+		}
+	})
+}
+
+// isInline reports whether elements of the kind are inline content, which the lines of a paragraph are made of.
+func isInline(kind ElementKind) bool {
+	switch kind {
+	case EKText, EKBold, EKItalic, EKStrike, EKCodeSpan, EKLink, EKImage, EKRaw, EKHardBreak, EKHTMLInline, EKFootnoteRef:
+		return true
+	}
+	return false
+}
+
+// linkSpace reports whether a space is written between the link prev and the inline element next, so the Builder
+// keeps a link apart from a link or a span after it. Text carries its own whitespace and gets none, nor does a hard
+// break. The parsers drop the space they read back there.
+func linkSpace(prev, next *Element) bool {
+	return prev.Kind == EKLink && !prev.LineBreak && isInline(next.Kind) && next.Kind != EKText && next.Kind != EKHardBreak
+}
+
+// paragraphs groups each run of inline elements among the blocks into an EKParagraph.
+func paragraphs(elements []*Element) []*Element {
+	var out []*Element
+	var open *Element // the paragraph of the current run of inline elements
+	for _, el := range elements {
+		switch {
+		case el == nil:
+		case isInline(el.Kind):
+			if open == nil {
+				open = &Element{Kind: EKParagraph}
+				out = append(out, open)
+			}
+			open.Children = append(open.Children, el)
+		default:
+			open = nil
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// groupParagraphs groups the lines of inline elements into paragraphs, in the elements and in the quotes and
+// list items among them.
+func groupParagraphs(elements []*Element) []*Element {
+	out := paragraphs(elements)
+	walkBlocks(out, func(el *Element) {
+		if el.Kind == EKQuote || el.Kind == EKListItem {
+			el.Children = paragraphs(el.Children)
+		}
+	})
+	return out
+}
+
 // btoi converts a boolean to an integer.
 func btoi(b bool) int {
 	if b {